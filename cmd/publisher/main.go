@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,7 +11,9 @@ import (
 
 	"github.com/akashipov/L0project/internal/storage/order"
 	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/tracing"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Replace(s, suffix string) string {
@@ -31,7 +34,16 @@ func main() {
 	}
 	defer sc.Close()
 	p := flag.String("f", "statics/publisher/order.json", "Path to example of order in json format")
+	te := flag.String("te", "none", "Trace exporter: none, stdout or otlp-file")
+	tp := flag.String("tp", "publisher-traces.jsonl", "File of otlp-file trace exporter")
 	flag.Parse()
+	ctx := context.Background()
+	shutdown, err := tracing.Init(ctx, tracing.Config{Exporter: *te, ServiceName: "l0-publisher", Path: *tp, SampleRatio: 1})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer shutdown(ctx)
 	data, err := postgres.Read(*p)
 	if err != nil {
 		fmt.Println(err.Error())
@@ -62,7 +74,16 @@ func main() {
 			fmt.Println("Problem with json data: " + err.Error())
 			return
 		}
-		err = sc.Publish("foo", d)
+		// trace context in headers links storing of order to this publish
+		msg := &nats.Msg{Subject: "foo", Data: d}
+		spanCtx, span := tracing.Start(ctx, "nats publish foo",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(tracing.OrderID(ord.OrderID)),
+		)
+		tracing.Inject(spanCtx, msg)
+		err = sc.PublishMsg(msg)
+		tracing.Fail(span, err)
+		span.End()
 		if err != nil {
 			fmt.Println(err.Error())
 			return
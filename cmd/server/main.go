@@ -2,78 +2,239 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/akashipov/L0project/internal/admin"
 	"github.com/akashipov/L0project/internal/arguments"
+	"github.com/akashipov/L0project/internal/breaker"
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/grpcserver"
+	"github.com/akashipov/L0project/internal/health"
+	"github.com/akashipov/L0project/internal/ingest"
+	"github.com/akashipov/L0project/internal/invalidation"
+	"github.com/akashipov/L0project/internal/metrics"
 	"github.com/akashipov/L0project/internal/pkg/middleware/logger"
+	"github.com/akashipov/L0project/internal/relay"
 	"github.com/akashipov/L0project/internal/server"
+	"github.com/akashipov/L0project/internal/storage/cache"
 	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/tracing"
+	"github.com/akashipov/L0project/internal/webhooks"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/zap"
 )
 
-func SignalWorker(done chan struct{}, w *sync.WaitGroup) {
+func SignalWorker(stop chan struct{}, log *zap.SugaredLogger) {
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigint
-	fmt.Printf("\nSignal: %v\n", sig)
-	done <- struct{}{}
-	w.Done()
+	log.Infow("Signal is received", "signal", sig.String())
+	health.Default.Shutdown()
+	close(stop)
+}
+
+// phase runs one step of shutdown within timeout and logs how long it took
+func phase(log *zap.SugaredLogger, name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	log.Infow("Shutdown phase is started", "phase", name, "timeout", timeout.String())
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := fn(ctx)
+	if err != nil {
+		log.Warnw("Shutdown phase is failed", "phase", name, "duration", time.Since(start).String(), "error", err)
+		return
+	}
+	log.Infow("Shutdown phase is finished", "phase", name, "duration", time.Since(start).String())
 }
 
 func main() {
+	stop := make(chan struct{})
 	done := make(chan struct{})
 	ctx := context.Background()
 	var w sync.WaitGroup
-	w.Add(1)
-	go SignalWorker(done, &w)
 	err := arguments.ParseArgsServer()
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
+	log, err := logger.GetLogger()
+	if err != nil {
+		fmt.Println("Log creation problem " + err.Error())
+		return
+	}
+	defer log.Sync()
+	zap.ReplaceGlobals(log.Desugar())
+	arguments.LogConfig(log)
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		Exporter:    arguments.TraceExporter,
+		ServiceName: "l0-server",
+		Path:        arguments.TracePath,
+		SampleRatio: arguments.TraceSampleRatio,
+	})
+	if err != nil {
+		log.Errorw("Problem with initialization of tracing", "error", err)
+		return
+	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			log.Warnw("Problem with flushing of traces", "error", err)
+		}
+	}()
+	go SignalWorker(stop, log)
+
+	events.Stored = events.NewHub(arguments.StreamReplaySize)
 
 	// Load cache from psql db to local memory
-	_, err = postgres.NewSqlWorker()
+	_, err = postgres.NewSqlWorker(log.Named("postgres"))
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Errorw("Problem with connection to Psql db", "error", err)
 		return
 	}
+	metrics.Registry.MustRegister(
+		collectors.NewDBStatsCollector(postgres.DBWorker.DB, "l0_data"),
+		cache.NewCollector(),
+	)
 	err = postgres.DBWorker.CreateDefaultTables()
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Errorw("Problem with creation of tables", "error", err)
 		return
 	}
 
 	sc, err := nats.Connect(arguments.NatsURL)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Errorw("Problem with connection to nats", "url", arguments.NatsURL, "error", err)
 		return
 	}
-	sc.Subscribe("foo", func(m *nats.Msg) {
-		fmt.Printf("Received a message\n")
-		err := postgres.DBWorker.AddData(ctx, m.Data)
-		if err != nil {
-			fmt.Println("Some error with adding data: " + err.Error())
+	inv := invalidation.NewInvalidator(sc, arguments.CacheInvalidationSubject, log.Named("invalidation"))
+	err = inv.Subscribe()
+	if err != nil {
+		log.Errorw("Problem with subscription to cache invalidation", "subject", arguments.CacheInvalidationSubject, "error", err)
+		return
+	}
+	postgres.DBWorker.OnChange = inv.Publish
+	postgres.DBWorker.QueryTimeout = time.Duration(arguments.PostgresQueryTimeoutMs) * time.Millisecond
+	if arguments.PostgresBreakerFailures > 0 {
+		breakerLog := log.Named("breaker")
+		postgres.DBWorker.Breaker = breaker.New(breaker.Config{
+			Failures:    arguments.PostgresBreakerFailures,
+			OpenTimeout: time.Duration(arguments.PostgresBreakerOpenSecs) * time.Second,
+			Probes:      arguments.PostgresBreakerProbes,
+		})
+		postgres.DBWorker.Breaker.OnChange = func(from, to string) {
+			breakerLog.Warnw("Psql db circuit breaker is changed", "from", from, "to", to)
+			metrics.SetBreakerState(to)
 		}
-	})
-
-	log, err := logger.GetLogger()
+	}
+	metrics.SetBreakerState(postgres.DBWorker.Breaker.State())
+	natsLog := log.Named("nats")
+	cons, err := ingest.NewConsumer(sc, natsLog)
 	if err != nil {
-		fmt.Println("Log creation problem " + err.Error())
+		log.Errorw("Problem with JetStream", "error", err)
+		return
+	}
+	cons.Pause = postgres.DBWorker.Breaker.RetryAfter
+	orders := &ingest.Orders{
+		Storage: &postgres.DBWorker,
+		Timeout: time.Duration(arguments.NatsMessageTimeoutSecs) * time.Second,
+		Log:     natsLog,
+	}
+	cons.Handle = orders.Handle
+	err = cons.Subscribe()
+	if err != nil {
+		log.Errorw("Problem with subscription to orders", "subject", ingest.Subject, "error", err)
 		return
 	}
+	go cons.Run(stop)
+	health.Default.Add("postgres", postgres.DBWorker.DB.PingContext)
+	health.Default.Add("nats", func(ctx context.Context) error {
+		if st := sc.Status(); st != nats.CONNECTED {
+			return fmt.Errorf("Nats connection is %s", st.String())
+		}
+		return nil
+	})
+	health.Default.Add("cache_warmup", func(ctx context.Context) error {
+		if !cache.Warmed() {
+			return errors.New("Cache warmup is not finished")
+		}
+		return nil
+	})
+	health.Default.Add("schema", postgres.DBWorker.CheckSchema)
 	srv, err := server.NewServer(ctx, *log)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Errorw("Problem with creation of http server", "error", err)
 		return
 	}
 	w.Add(1)
 	go srv.RunServer(done, &w)
-	w.Wait()
+	w.Add(1)
+	go grpcserver.NewServer(log.Named("grpc")).RunServer(done, &w)
+	w.Add(1)
+	go webhooks.NewDispatcher(log.Named("webhooks")).Run(done, &w)
+	adm, err := admin.NewServer(log.Named("admin"))
+	if err != nil {
+		log.Warnw("Admin server is not started", "error", err)
+	} else {
+		w.Add(1)
+		go adm.RunServer(done, &w)
+	}
+	expvar.Publish("cache", expvar.Func(func() any {
+		return cache.GetReport()
+	}))
+	expvar.Publish("cache_invalidation", expvar.Func(func() any {
+		return inv.Stats()
+	}))
+	rel, err := relay.NewRelay(sc, log.Named("relay"))
+	if err != nil {
+		log.Errorw("Nats outbox relay is not started", "error", err)
+	} else {
+		expvar.Publish("nats_outbox_relay", expvar.Func(func() any {
+			return rel.Stats()
+		}))
+		// streams of every replica get orders relayed after commit
+		_, err = rel.Feed(events.Stored)
+		if err != nil {
+			log.Errorw("Streams of stored orders are not fed", "error", err)
+		}
+		w.Add(1)
+		go rel.Run(done, &w)
+	}
+
+	<-stop
+	phase(log, "nats drain", time.Duration(arguments.ShutdownNatsSecs)*time.Second, cons.Wait)
+	phase(log, "postgres writes", time.Duration(arguments.ShutdownWritesSecs)*time.Second, postgres.DBWorker.Wait)
+	// http requests are finished by Shutdown of server, other workers stop at once
+	phase(log, "servers", time.Duration(arguments.ShutdownHTTPSecs+1)*time.Second, func(ctx context.Context) error {
+		close(done)
+		stopped := make(chan struct{})
+		go func() {
+			w.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("Servers are not stopped: %w", ctx.Err())
+		}
+	})
+	if arguments.CacheSnapshotPath != "" {
+		n, err := cache.SaveSnapshot(arguments.CacheSnapshotPath, cache.LRUCache)
+		if err != nil {
+			log.Errorw("Problem with saving of cache snapshot", "path", arguments.CacheSnapshotPath, "error", err)
+		} else {
+			log.Infow("Cache snapshot was saved", "path", arguments.CacheSnapshotPath, "orders", n)
+		}
+	}
 	sc.Close()
-	fmt.Println("Subscription was closed!")
+	log.Infof("Nats connection is closed")
+	phase(log, "postgres pool", time.Duration(arguments.ShutdownWritesSecs)*time.Second, postgres.DBWorker.Close)
 }
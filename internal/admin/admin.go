@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akashipov/L0project/internal/arguments"
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/pkg/middleware/logger"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/akashipov/L0project/internal/storage/history"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/webhooks"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultKeysLimit = 100
+	// AccessWindow is period of order hits returned with key
+	AccessWindow = 24 * time.Hour
+)
+
+// Server serves admin api on its own listener, so it can be kept
+// in private network while public api is exposed
+type Server struct {
+	Srv *http.Server
+	Log *zap.SugaredLogger
+}
+
+// NewServer returns error when admin password is not configured
+func NewServer(log *zap.SugaredLogger) (*Server, error) {
+	if arguments.AdminPassword == "" {
+		return nil, errors.New("Admin password is not set, admin server is disabled")
+	}
+	return &Server{
+		Srv: &http.Server{
+			Addr:    arguments.AdminServer,
+			Handler: Router(log, arguments.AdminUser, arguments.AdminPassword),
+		},
+		Log: log,
+	}, nil
+}
+
+func (s *Server) RunServer(done chan struct{}, w *sync.WaitGroup) {
+	defer w.Done()
+	go func() {
+		err := s.Srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Log.Errorw("Admin server error", "error", err)
+		}
+	}()
+	<-done
+	timeout := time.Duration(arguments.ShutdownHTTPSecs) * time.Second
+	s.Log.Infow("Admin server is stopping...", "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Srv.Shutdown(ctx)
+	if err != nil {
+		s.Log.Warnw("Admin requests are not finished in time, connections are closed", "error", err)
+		s.Srv.Close()
+	}
+	s.Log.Infof("Admin server is stopped")
+}
+
+// Router checks admin credentials with basic auth on every route
+func Router(log *zap.SugaredLogger, user string, password string) http.Handler {
+	h := &handlers{Log: log}
+	r := chi.NewRouter()
+	r.Use(middleware.BasicAuth("admin", map[string]string{user: password}))
+	r.Get("/cache/stats", h.Stats)
+	r.Get("/cache/keys", h.Keys)
+	r.Get("/cache/keys/{id}", h.Key)
+	r.Delete("/cache/keys/{id}", h.EvictKey)
+	r.Delete("/cache", h.Purge)
+	r.Post("/cache/rewarm", h.Rewarm)
+	r.Get("/log/level", logger.Level.ServeHTTP)
+	r.Put("/log/level", h.SetLogLevel)
+	r.Mount("/webhooks", webhooks.Router())
+	return r
+}
+
+type handlers struct {
+	Log *zap.SugaredLogger
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		cErr := customerrors.CustomError{Message: err.Error(), Status: http.StatusInternalServerError}
+		cErr.ReportError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (h *handlers) Stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cache.GetReport())
+}
+
+// Keys are listed from the most recently used, 'limit' query parameter bounds their number
+func (h *handlers) Keys(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			cErr := customerrors.CustomError{Message: fmt.Sprintf("Bad limit '%s'", v), Status: http.StatusBadRequest}
+			cErr.ReportError(w)
+			return
+		}
+		limit = n
+	}
+	infos := cache.Inspect()
+	total := len(infos)
+	if len(infos) > limit {
+		infos = infos[:limit]
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Total int             `json:"total"`
+		Keys  []cache.KeyInfo `json:"keys"`
+	}{total, infos})
+}
+
+// KeyDetails is cached order with its hits by hours
+type KeyDetails struct {
+	cache.KeyInfo
+	Access []history.AccessBucket `json:"access,omitempty"`
+}
+
+func (h *handlers) Key(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	info, ok := cache.InspectKey(id)
+	if !ok {
+		cErr := customerrors.CustomError{Message: fmt.Sprintf("Order '%s' is not cached", id), Status: http.StatusNotFound}
+		cErr.ReportError(w)
+		return
+	}
+	details := KeyDetails{KeyInfo: info}
+	access, err := postgres.DBWorker.GetOrderAccess(r.Context(), id, time.Now().Add(-AccessWindow))
+	if err != nil {
+		h.Log.Warnf("Problem with getting of order access: %s", err.Error())
+	} else {
+		details.Access = access
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func (h *handlers) EvictKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !cache.Evict(id) {
+		cErr := customerrors.CustomError{Message: fmt.Sprintf("Order '%s' is not cached", id), Status: http.StatusNotFound}
+		cErr.ReportError(w)
+		return
+	}
+	h.Log.Infof("Order '%s' was evicted from cache by admin", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handlers) Purge(w http.ResponseWriter, r *http.Request) {
+	cache.Purge()
+	h.Log.Infof("Cache was purged by admin")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Rewarm loads orders by 'strategy' query parameter, last requested orders are loaded by default
+func (h *handlers) Rewarm(w http.ResponseWriter, r *http.Request) {
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = history.WarmupRecent
+	}
+	known := false
+	for _, s := range history.WarmupStrategies {
+		known = known || s == strategy
+	}
+	if !known {
+		cErr := customerrors.CustomError{
+			Message: fmt.Sprintf("Unknown warmup strategy '%s', expected one of %v", strategy, history.WarmupStrategies),
+			Status:  http.StatusBadRequest,
+		}
+		cErr.ReportError(w)
+		return
+	}
+	// warmup is not cancelled when admin closes connection
+	st, err := cache.Rewarm(context.Background(), strategy)
+	if err != nil {
+		cErr := customerrors.CustomError{Message: err.Error(), Status: http.StatusInternalServerError}
+		cErr.ReportError(w)
+		return
+	}
+	h.Log.Infof("Cache was rewarmed by admin: %d of %d orders loaded", st.Loaded, st.Requested)
+	writeJSON(w, http.StatusOK, st)
+}
+
+// SetLogLevel changes level of every logger, body is '{"level": "debug"}'
+func (h *handlers) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	prev := logger.Level.Level()
+	logger.Level.ServeHTTP(w, r)
+	if level := logger.Level.Level(); level != prev {
+		h.Log.Warnw("Log level was changed by admin", "from", prev.String(), "to", level.String())
+	}
+}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/pkg/middleware/logger"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRouter(t *testing.T) {
+	prev := cache.LRUCache
+	defer func() { cache.LRUCache = prev }()
+	cache.LRUCache = cache.NewExpirableLRU(10, time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.LRUCache.Set(key, cache.Entry{Data: []byte("order " + key), StoredAt: time.Now()})
+	}
+	cache.LRUCache.Get("a")
+
+	srv := httptest.NewServer(Router(zap.NewNop().Sugar(), "admin", "secret"))
+	defer srv.Close()
+	do := func(method string, path string, user string, password string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.Equal(t, nil, err)
+		req.SetBasicAuth(user, password)
+		res, err := http.DefaultClient.Do(req)
+		require.Equal(t, nil, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		res := do(http.MethodGet, "/cache/stats", "admin", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		res = do(http.MethodDelete, "/cache", "other", "secret")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		res = do(http.MethodPost, "/webhooks/", "admin", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		res = do(http.MethodDelete, "/webhooks/1", "", "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, 3, cache.LRUCache.Stats().Size)
+	})
+	t.Run("stats", func(t *testing.T) {
+		res := do(http.MethodGet, "/cache/stats", "admin", "secret")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var report cache.Report
+		require.Equal(t, nil, json.NewDecoder(res.Body).Decode(&report))
+		assert.Equal(t, 3, report.Size)
+		assert.Equal(t, uint64(1), report.Hits)
+	})
+	t.Run("keys", func(t *testing.T) {
+		res := do(http.MethodGet, "/cache/keys?limit=2", "admin", "secret")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var body struct {
+			Total int             `json:"total"`
+			Keys  []cache.KeyInfo `json:"keys"`
+		}
+		require.Equal(t, nil, json.NewDecoder(res.Body).Decode(&body))
+		assert.Equal(t, 3, body.Total)
+		require.Len(t, body.Keys, 2)
+		// the most recently used first
+		assert.Equal(t, "a", body.Keys[0].Key)
+		assert.Equal(t, "c", body.Keys[1].Key)
+		assert.Equal(t, len("order a"), body.Keys[0].Bytes)
+		assert.InDelta(t, 60, body.Keys[0].TTLSecs, 5)
+
+		res = do(http.MethodGet, "/cache/keys?limit=0", "admin", "secret")
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+	t.Run("evict", func(t *testing.T) {
+		res := do(http.MethodDelete, "/cache/keys/b", "admin", "secret")
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		res = do(http.MethodDelete, "/cache/keys/b", "admin", "secret")
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		res = do(http.MethodGet, "/cache/keys/b", "admin", "secret")
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+	t.Run("rewarm_unknown_strategy", func(t *testing.T) {
+		res := do(http.MethodPost, "/cache/rewarm?strategy=random", "admin", "secret")
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+	t.Run("log_level", func(t *testing.T) {
+		prev := logger.Level.Level()
+		defer logger.Level.SetLevel(prev)
+		logger.Level.SetLevel(zap.InfoLevel)
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/log/level", strings.NewReader(`{"level": "debug"}`))
+		require.Equal(t, nil, err)
+		req.SetBasicAuth("admin", "secret")
+		res, err := http.DefaultClient.Do(req)
+		require.Equal(t, nil, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, zap.DebugLevel, logger.Level.Level())
+
+		res = do(http.MethodGet, "/log/level", "admin", "secret")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var body struct {
+			Level string `json:"level"`
+		}
+		require.Equal(t, nil, json.NewDecoder(res.Body).Decode(&body))
+		assert.Equal(t, "debug", body.Level)
+	})
+	t.Run("purge", func(t *testing.T) {
+		res := do(http.MethodDelete, "/cache", "admin", "secret")
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		assert.Equal(t, 0, cache.LRUCache.Stats().Size)
+	})
+}
@@ -3,22 +3,139 @@ package arguments
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/caarlos0/env/v6"
+	"go.uber.org/zap"
 )
 
 var PostgresPWD string
 var NatsURL string
 var HPServer string
+var GRPCServer string
+var AdminServer string
+var LogLevel string
+var LogFormat string
+var LogPreset string
+var TraceExporter string
+var TracePath string
+var TraceSampleRatio float64
+var HTTPCompression string
+var HTTPCompressionMinSize int
+var HTTPRouteTimeouts string
+var HTTPReadTimeoutSecs int
+var HTTPReadHeaderTimeoutSecs int
+var HTTPWriteTimeoutSecs int
+var HTTPIdleTimeoutSecs int
+var PostgresQueryTimeoutMs int
+var PostgresBreakerFailures int
+var PostgresBreakerOpenSecs int
+var PostgresBreakerProbes int
+var NatsMessageTimeoutSecs int
+var AccessLogFormat string
+var AccessLogOutput string
+var AccessLogMaxSizeMB int
+var AccessLogMaxBackups int
+var AccessLogSampleRate float64
+var AdminUser string
+var AdminPassword string
 var CacheSize int
+var CacheType string
+var CacheShards int
+var CacheMaxBytes int64
+var CacheMaxEntryBytes int64
 var CacheTimeLimitSecs int
+var CacheSoftLimitSecs int
+var CacheRetentionSecs int
+var CacheRefreshConcurrency int
+var CacheInvalidationSubject string
+var CacheSnapshotPath string
+var CacheWarmupStrategy string
+var CacheWarmupWindowHours int
+var CacheWarmupConcurrency int
+var CacheWarmupBudgetSecs int
+var CacheNegativeSize int
+var CacheNegativeTimeLimitSecs int
+var CacheBloomExpected int
+var CacheBloomFalsePositive float64
+var CacheBloomRebuildSecs int
+var CacheEncodings string
+var StreamReplaySize int
+var StreamAllowedOrigins string
+var WebhookMaxAttempts int
+var WebhookBackoffSecs int
+var ShutdownNatsSecs int
+var ShutdownWritesSecs int
+var ShutdownHTTPSecs int
 
+// ServerEnvConfig overrides flags, pointer fields are ones where
+// zero value is meaningful, e.g. 0 disables feature, so set 0 differs from unset
 type ServerEnvConfig struct {
-	PostgresPWD        string `env:"POSTGRES_PWD"`
-	NatsURL            string `env:"NATS_URL"`
-	HPServer           string `env:"HTTP_URL"`
-	CacheSize          int    `env:"CACHE_SIZE"`
-	CacheTimeLimitSecs int    `env:"CACHE_LIMIT_SECS"`
+	PostgresPWD                string   `env:"POSTGRES_PWD"`
+	NatsURL                    string   `env:"NATS_URL"`
+	HPServer                   string   `env:"HTTP_URL"`
+	GRPCServer                 string   `env:"GRPC_URL"`
+	HTTPCompression            string   `env:"HTTP_COMPRESSION"`
+	HTTPCompressionMinSize     *int     `env:"HTTP_COMPRESSION_MIN_SIZE"`
+	HTTPRouteTimeouts          string   `env:"HTTP_ROUTE_TIMEOUTS"`
+	HTTPReadTimeoutSecs        int      `env:"HTTP_READ_TIMEOUT_SECS"`
+	HTTPReadHeaderTimeoutSecs  int      `env:"HTTP_READ_HEADER_TIMEOUT_SECS"`
+	HTTPWriteTimeoutSecs       int      `env:"HTTP_WRITE_TIMEOUT_SECS"`
+	HTTPIdleTimeoutSecs        int      `env:"HTTP_IDLE_TIMEOUT_SECS"`
+	PostgresQueryTimeoutMs     *int     `env:"POSTGRES_QUERY_TIMEOUT_MS"`
+	PostgresBreakerFailures    *int     `env:"POSTGRES_BREAKER_FAILURES"`
+	PostgresBreakerOpenSecs    int      `env:"POSTGRES_BREAKER_OPEN_SECS"`
+	PostgresBreakerProbes      int      `env:"POSTGRES_BREAKER_PROBES"`
+	NatsMessageTimeoutSecs     int      `env:"NATS_MESSAGE_TIMEOUT_SECS"`
+	AccessLogFormat            string   `env:"ACCESS_LOG_FORMAT"`
+	AccessLogOutput            string   `env:"ACCESS_LOG_OUTPUT"`
+	AccessLogMaxSizeMB         int      `env:"ACCESS_LOG_MAX_SIZE_MB"`
+	AccessLogMaxBackups        int      `env:"ACCESS_LOG_MAX_BACKUPS"`
+	AccessLogSampleRate        *float64 `env:"ACCESS_LOG_SAMPLE_RATE"`
+	LogLevel                   string   `env:"LOG_LEVEL"`
+	LogFormat                  string   `env:"LOG_FORMAT"`
+	LogPreset                  string   `env:"LOG_PRESET"`
+	TraceExporter              string   `env:"TRACE_EXPORTER"`
+	TracePath                  string   `env:"TRACE_PATH"`
+	TraceSampleRatio           *float64 `env:"TRACE_SAMPLE_RATIO"`
+	AdminServer                string   `env:"ADMIN_URL"`
+	AdminUser                  string   `env:"ADMIN_USER"`
+	AdminPassword              string   `env:"ADMIN_PASSWORD"`
+	CacheSize                  int      `env:"CACHE_SIZE"`
+	CacheType                  string   `env:"CACHE_TYPE"`
+	CacheShards                int      `env:"CACHE_SHARDS"`
+	CacheMaxBytes              int64    `env:"CACHE_MAX_BYTES"`
+	CacheMaxEntryBytes         int64    `env:"CACHE_MAX_ENTRY_BYTES"`
+	CacheTimeLimitSecs         int      `env:"CACHE_LIMIT_SECS"`
+	CacheSoftLimitSecs         *int     `env:"CACHE_SOFT_LIMIT_SECS"`
+	CacheRetentionSecs         *int     `env:"CACHE_RETENTION_SECS"`
+	CacheRefreshConcurrency    int      `env:"CACHE_REFRESH_CONCURRENCY"`
+	CacheInvalidationSubject   string   `env:"CACHE_INVALIDATION_SUBJECT"`
+	CacheSnapshotPath          string   `env:"CACHE_SNAPSHOT_PATH"`
+	CacheWarmupStrategy        string   `env:"CACHE_WARMUP_STRATEGY"`
+	CacheWarmupWindowHours     int      `env:"CACHE_WARMUP_WINDOW_HOURS"`
+	CacheWarmupConcurrency     int      `env:"CACHE_WARMUP_CONCURRENCY"`
+	CacheWarmupBudgetSecs      int      `env:"CACHE_WARMUP_BUDGET_SECS"`
+	CacheNegativeSize          *int     `env:"CACHE_NEGATIVE_SIZE"`
+	CacheNegativeTimeLimitSecs int      `env:"CACHE_NEGATIVE_LIMIT_SECS"`
+	CacheBloomExpected         *int     `env:"CACHE_BLOOM_EXPECTED"`
+	CacheBloomFalsePositive    float64  `env:"CACHE_BLOOM_FALSE_POSITIVE"`
+	CacheBloomRebuildSecs      *int     `env:"CACHE_BLOOM_REBUILD_SECS"`
+	CacheEncodings             string   `env:"CACHE_ENCODINGS"`
+	StreamReplaySize           *int     `env:"STREAM_REPLAY_SIZE"`
+	StreamAllowedOrigins       string   `env:"STREAM_ALLOWED_ORIGINS"`
+	WebhookMaxAttempts         int      `env:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookBackoffSecs         int      `env:"WEBHOOK_BACKOFF_SECS"`
+	ShutdownNatsSecs           int      `env:"SHUTDOWN_NATS_SECS"`
+	ShutdownWritesSecs         int      `env:"SHUTDOWN_WRITES_SECS"`
+	ShutdownHTTPSecs           int      `env:"SHUTDOWN_HTTP_SECS"`
+}
+
+// present reports variable which is set even to empty value,
+// it disables feature instead of keeping value of flag
+func present(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
 }
 
 func ParseArgsServer() error {
@@ -30,8 +147,61 @@ func ParseArgsServer() error {
 	p := flag.String("p", "", "password of postgres db")
 	n := flag.String("n", "0.0.0.0:4222", "Nats <host>:<port> to connect")
 	cs := flag.Int("cs", 5, "Cache max capacity")
+	ct := flag.String("ct", "lru", "Cache implementation: 'lru' with single lock, 'sharded' or 'bytes' sized in bytes")
+	csh := flag.Int("csh", 16, "Number of shards of 'sharded' cache, capacity is split between them")
+	cmb := flag.Int64("cmb", 64<<20, "Memory budget in bytes of 'bytes' cache")
+	cmeb := flag.Int64("cmeb", 1<<20, "Max size in bytes of one order in 'bytes' cache, bigger ones are not cached")
 	ctl := flag.Int("ctl", 5, "Cache time limit on value in the table")
+	cstl := flag.Int("cstl", 0, "Cache soft time limit, older values are served while refreshed in background, 0 disables it")
+	crt := flag.Int("crt", 300, "Seconds values are kept after cache time limit to be served while Psql db is unavailable")
+	crc := flag.Int("crc", 4, "Max number of background cache refreshes at the same time")
+	cis := flag.String("cis", "orders.invalidate", "Nats subject to notify replicas about changed orders")
+	csp := flag.String("csp", "cache.snapshot", "File to save cache on shutdown and to load it on start, empty disables it")
+	cws := flag.String("cws", "recent", "Cache warmup strategy: 'recent', 'frequent' or 'hybrid'")
+	cww := flag.Int("cww", 168, "Hours of order requests taken into account by 'frequent' and 'hybrid' warmup")
+	cwc := flag.Int("cwc", 8, "Number of orders loaded at the same time during warmup")
+	cwb := flag.Int("cwb", 10, "Cache warmup time budget in seconds, orders not loaded in time are skipped")
+	cns := flag.Int("cns", 1024, "Number of remembered not found order ids, 0 disables it")
+	cntl := flag.Int("cntl", 2, "Time limit in seconds on remembered not found order id")
+	cbe := flag.Int("cbe", 0, "Expected number of orders in filter of known ids, 0 disables the filter")
+	cbfp := flag.Float64("cbfp", 0.01, "False positive rate of filter of known ids")
+	cbr := flag.Int("cbr", 600, "Interval in seconds of rebuilding of filter of known ids from Psql db, 0 disables it")
+	ce := flag.String("ce", "gzip", "Comma separated content encodings kept compressed in cache: 'gzip', 'zstd', 'br'")
+	rs := flag.Int("rs", 256, "Number of last stored orders kept to resume streams")
+	so := flag.String("so", "", "Comma separated origins allowed to open websocket stream besides the same origin, e.g. 'https://shop.example'")
+	wa := flag.Int("wa", 8, "Webhook delivery attempts before dead letter")
+	wb := flag.Int("wb", 1, "Webhook backoff in seconds after first failed attempt, it doubles after each next one")
+	sn := flag.Int("sn", 10, "Seconds to finish fetched nats messages on shutdown")
+	sw := flag.Int("sw", 10, "Seconds to finish in-flight Psql db transactions on shutdown")
+	sh := flag.Int("sh", 15, "Seconds to finish in-flight http requests on shutdown, then connections are closed")
 	s := flag.String("s", "0.0.0.0:8000", "Nats <host>:<port> to connect")
+	g := flag.String("g", "0.0.0.0:9000", "Grpc server <host>:<port> to listen")
+	ll := flag.String("ll", "info", "Log level: debug, info, warn or error")
+	lf := flag.String("lf", "console", "Log format: console or json")
+	lp := flag.String("lp", "development", "Log preset: development or production")
+	te := flag.String("te", "none", "Trace exporter: none, stdout, otlp-file or memory")
+	tp := flag.String("tp", "traces.jsonl", "File of otlp-file trace exporter")
+	tr := flag.Float64("tr", 1, "Fraction of new traces which are recorded")
+	hc := flag.String("hc", "zstd,br,gzip", "Http response encodings by preference, empty disables compression")
+	hcm := flag.Int("hcm", 1024, "Http response size in bytes from which it is compressed")
+	hrt := flag.String("hrt", "*=5s,/order/{id}=2s", "Comma separated 'route=duration' deadlines of http requests, '*' is for other routes, streams have none")
+	htr := flag.Int("htr", 10, "Seconds to read whole http request")
+	hth := flag.Int("hth", 5, "Seconds to read headers of http request")
+	htw := flag.Int("htw", 30, "Seconds to write http response, streams are not limited")
+	hti := flag.Int("hti", 120, "Seconds to keep idle http connection")
+	pqt := flag.Int("pqt", 2000, "Milliseconds of deadline of one Psql db query, 0 disables it")
+	pbf := flag.Int("pbf", 5, "Failures of Psql db in a row which open circuit breaker, 0 disables it")
+	pbo := flag.Int("pbo", 10, "Seconds of open circuit breaker before Psql db is probed again")
+	pbp := flag.Int("pbp", 1, "Calls let through half-open circuit breaker, all of them have to succeed to close it")
+	nmt := flag.Int("nmt", 10, "Seconds to store one nats message to Psql db")
+	alf := flag.String("alf", "json", "Access log format: json, common or combined")
+	alo := flag.String("alo", "stdout", "Access log output: stdout or path to file")
+	alms := flag.Int("alms", 100, "Access log file size in megabytes after which it is rotated")
+	alb := flag.Int("alb", 3, "Number of rotated access log files to keep")
+	alsr := flag.Float64("alsr", 1, "Fraction of responses with status 200 which are written to access log")
+	a := flag.String("a", "127.0.0.1:8081", "Admin server <host>:<port> to listen")
+	au := flag.String("au", "admin", "Admin user")
+	ap := flag.String("ap", "", "Admin password, admin server is disabled without it")
 	flag.Parse()
 	if p != nil {
 		PostgresPWD = *p
@@ -39,33 +209,412 @@ func ParseArgsServer() error {
 	if cs != nil {
 		CacheSize = *cs
 	}
+	if ct != nil {
+		CacheType = *ct
+	}
+	if csh != nil {
+		CacheShards = *csh
+	}
+	if cmb != nil {
+		CacheMaxBytes = *cmb
+	}
+	if cmeb != nil {
+		CacheMaxEntryBytes = *cmeb
+	}
 	if ctl != nil {
 		CacheTimeLimitSecs = *ctl
 	}
+	if cstl != nil {
+		CacheSoftLimitSecs = *cstl
+	}
+	if crt != nil {
+		CacheRetentionSecs = *crt
+	}
+	if crc != nil {
+		CacheRefreshConcurrency = *crc
+	}
+	if cis != nil {
+		CacheInvalidationSubject = *cis
+	}
+	if csp != nil {
+		CacheSnapshotPath = *csp
+	}
+	if cws != nil {
+		CacheWarmupStrategy = *cws
+	}
+	if cww != nil {
+		CacheWarmupWindowHours = *cww
+	}
+	if cwc != nil {
+		CacheWarmupConcurrency = *cwc
+	}
+	if cwb != nil {
+		CacheWarmupBudgetSecs = *cwb
+	}
+	if cns != nil {
+		CacheNegativeSize = *cns
+	}
+	if cntl != nil {
+		CacheNegativeTimeLimitSecs = *cntl
+	}
+	if cbe != nil {
+		CacheBloomExpected = *cbe
+	}
+	if cbfp != nil {
+		CacheBloomFalsePositive = *cbfp
+	}
+	if cbr != nil {
+		CacheBloomRebuildSecs = *cbr
+	}
+	if ce != nil {
+		CacheEncodings = *ce
+	}
+	if rs != nil {
+		StreamReplaySize = *rs
+	}
+	if so != nil {
+		StreamAllowedOrigins = *so
+	}
+	if wa != nil {
+		WebhookMaxAttempts = *wa
+	}
+	if wb != nil {
+		WebhookBackoffSecs = *wb
+	}
+	if sn != nil {
+		ShutdownNatsSecs = *sn
+	}
+	if sw != nil {
+		ShutdownWritesSecs = *sw
+	}
+	if sh != nil {
+		ShutdownHTTPSecs = *sh
+	}
 	if n != nil {
 		NatsURL = *n
 	}
 	if s != nil {
 		HPServer = *s
 	}
+	if g != nil {
+		GRPCServer = *g
+	}
+	if ll != nil {
+		LogLevel = *ll
+	}
+	if lf != nil {
+		LogFormat = *lf
+	}
+	if lp != nil {
+		LogPreset = *lp
+	}
+	if te != nil {
+		TraceExporter = *te
+	}
+	if tp != nil {
+		TracePath = *tp
+	}
+	if tr != nil {
+		TraceSampleRatio = *tr
+	}
+	if hc != nil {
+		HTTPCompression = *hc
+	}
+	if hcm != nil {
+		HTTPCompressionMinSize = *hcm
+	}
+	if hrt != nil {
+		HTTPRouteTimeouts = *hrt
+	}
+	if htr != nil {
+		HTTPReadTimeoutSecs = *htr
+	}
+	if hth != nil {
+		HTTPReadHeaderTimeoutSecs = *hth
+	}
+	if htw != nil {
+		HTTPWriteTimeoutSecs = *htw
+	}
+	if hti != nil {
+		HTTPIdleTimeoutSecs = *hti
+	}
+	if pqt != nil {
+		PostgresQueryTimeoutMs = *pqt
+	}
+	if pbf != nil {
+		PostgresBreakerFailures = *pbf
+	}
+	if pbo != nil {
+		PostgresBreakerOpenSecs = *pbo
+	}
+	if pbp != nil {
+		PostgresBreakerProbes = *pbp
+	}
+	if nmt != nil {
+		NatsMessageTimeoutSecs = *nmt
+	}
+	if alf != nil {
+		AccessLogFormat = *alf
+	}
+	if alo != nil {
+		AccessLogOutput = *alo
+	}
+	if alms != nil {
+		AccessLogMaxSizeMB = *alms
+	}
+	if alb != nil {
+		AccessLogMaxBackups = *alb
+	}
+	if alsr != nil {
+		AccessLogSampleRate = *alsr
+	}
+	if a != nil {
+		AdminServer = *a
+	}
+	if au != nil {
+		AdminUser = *au
+	}
+	if ap != nil {
+		AdminPassword = *ap
+	}
 	if cfg.HPServer != "" {
 		HPServer = cfg.HPServer
 	}
+	if cfg.GRPCServer != "" {
+		GRPCServer = cfg.GRPCServer
+	}
+	if cfg.LogLevel != "" {
+		LogLevel = cfg.LogLevel
+	}
+	if cfg.LogFormat != "" {
+		LogFormat = cfg.LogFormat
+	}
+	if cfg.LogPreset != "" {
+		LogPreset = cfg.LogPreset
+	}
+	if cfg.TraceExporter != "" {
+		TraceExporter = cfg.TraceExporter
+	}
+	if cfg.TracePath != "" {
+		TracePath = cfg.TracePath
+	}
+	if cfg.TraceSampleRatio != nil {
+		TraceSampleRatio = *cfg.TraceSampleRatio
+	}
+	if present("HTTP_COMPRESSION") {
+		HTTPCompression = cfg.HTTPCompression
+	}
+	if cfg.HTTPCompressionMinSize != nil {
+		HTTPCompressionMinSize = *cfg.HTTPCompressionMinSize
+	}
+	if cfg.HTTPRouteTimeouts != "" {
+		HTTPRouteTimeouts = cfg.HTTPRouteTimeouts
+	}
+	if cfg.HTTPReadTimeoutSecs != 0 {
+		HTTPReadTimeoutSecs = cfg.HTTPReadTimeoutSecs
+	}
+	if cfg.HTTPReadHeaderTimeoutSecs != 0 {
+		HTTPReadHeaderTimeoutSecs = cfg.HTTPReadHeaderTimeoutSecs
+	}
+	if cfg.HTTPWriteTimeoutSecs != 0 {
+		HTTPWriteTimeoutSecs = cfg.HTTPWriteTimeoutSecs
+	}
+	if cfg.HTTPIdleTimeoutSecs != 0 {
+		HTTPIdleTimeoutSecs = cfg.HTTPIdleTimeoutSecs
+	}
+	if cfg.PostgresQueryTimeoutMs != nil {
+		PostgresQueryTimeoutMs = *cfg.PostgresQueryTimeoutMs
+	}
+	if cfg.PostgresBreakerFailures != nil {
+		PostgresBreakerFailures = *cfg.PostgresBreakerFailures
+	}
+	if cfg.PostgresBreakerOpenSecs != 0 {
+		PostgresBreakerOpenSecs = cfg.PostgresBreakerOpenSecs
+	}
+	if cfg.PostgresBreakerProbes != 0 {
+		PostgresBreakerProbes = cfg.PostgresBreakerProbes
+	}
+	if cfg.NatsMessageTimeoutSecs != 0 {
+		NatsMessageTimeoutSecs = cfg.NatsMessageTimeoutSecs
+	}
+	if cfg.AccessLogFormat != "" {
+		AccessLogFormat = cfg.AccessLogFormat
+	}
+	if cfg.AccessLogOutput != "" {
+		AccessLogOutput = cfg.AccessLogOutput
+	}
+	if cfg.AccessLogMaxSizeMB != 0 {
+		AccessLogMaxSizeMB = cfg.AccessLogMaxSizeMB
+	}
+	if cfg.AccessLogMaxBackups != 0 {
+		AccessLogMaxBackups = cfg.AccessLogMaxBackups
+	}
+	if cfg.AccessLogSampleRate != nil {
+		AccessLogSampleRate = *cfg.AccessLogSampleRate
+	}
+	if cfg.AdminServer != "" {
+		AdminServer = cfg.AdminServer
+	}
+	if cfg.AdminUser != "" {
+		AdminUser = cfg.AdminUser
+	}
+	if cfg.AdminPassword != "" {
+		AdminPassword = cfg.AdminPassword
+	}
 	if cfg.CacheSize != 0 {
 		CacheSize = cfg.CacheSize
 	}
+	if cfg.CacheType != "" {
+		CacheType = cfg.CacheType
+	}
+	if cfg.CacheShards != 0 {
+		CacheShards = cfg.CacheShards
+	}
+	if cfg.CacheMaxBytes != 0 {
+		CacheMaxBytes = cfg.CacheMaxBytes
+	}
+	if cfg.CacheMaxEntryBytes != 0 {
+		CacheMaxEntryBytes = cfg.CacheMaxEntryBytes
+	}
 	if cfg.CacheTimeLimitSecs != 0 {
 		CacheTimeLimitSecs = cfg.CacheTimeLimitSecs
 	}
+	if cfg.CacheSoftLimitSecs != nil {
+		CacheSoftLimitSecs = *cfg.CacheSoftLimitSecs
+	}
+	if cfg.CacheRetentionSecs != nil {
+		CacheRetentionSecs = *cfg.CacheRetentionSecs
+	}
+	if cfg.CacheRefreshConcurrency != 0 {
+		CacheRefreshConcurrency = cfg.CacheRefreshConcurrency
+	}
+	if cfg.CacheInvalidationSubject != "" {
+		CacheInvalidationSubject = cfg.CacheInvalidationSubject
+	}
+	if present("CACHE_SNAPSHOT_PATH") {
+		CacheSnapshotPath = cfg.CacheSnapshotPath
+	}
+	if cfg.CacheWarmupStrategy != "" {
+		CacheWarmupStrategy = cfg.CacheWarmupStrategy
+	}
+	if cfg.CacheWarmupWindowHours != 0 {
+		CacheWarmupWindowHours = cfg.CacheWarmupWindowHours
+	}
+	if cfg.CacheWarmupConcurrency != 0 {
+		CacheWarmupConcurrency = cfg.CacheWarmupConcurrency
+	}
+	if cfg.CacheWarmupBudgetSecs != 0 {
+		CacheWarmupBudgetSecs = cfg.CacheWarmupBudgetSecs
+	}
+	if cfg.CacheNegativeSize != nil {
+		CacheNegativeSize = *cfg.CacheNegativeSize
+	}
+	if cfg.CacheNegativeTimeLimitSecs != 0 {
+		CacheNegativeTimeLimitSecs = cfg.CacheNegativeTimeLimitSecs
+	}
+	if cfg.CacheBloomExpected != nil {
+		CacheBloomExpected = *cfg.CacheBloomExpected
+	}
+	if cfg.CacheBloomFalsePositive != 0 {
+		CacheBloomFalsePositive = cfg.CacheBloomFalsePositive
+	}
+	if cfg.CacheBloomRebuildSecs != nil {
+		CacheBloomRebuildSecs = *cfg.CacheBloomRebuildSecs
+	}
+	if cfg.CacheEncodings != "" {
+		CacheEncodings = cfg.CacheEncodings
+	}
+	if cfg.StreamReplaySize != nil {
+		StreamReplaySize = *cfg.StreamReplaySize
+	}
+	if cfg.StreamAllowedOrigins != "" {
+		StreamAllowedOrigins = cfg.StreamAllowedOrigins
+	}
+	if cfg.WebhookMaxAttempts != 0 {
+		WebhookMaxAttempts = cfg.WebhookMaxAttempts
+	}
+	if cfg.WebhookBackoffSecs != 0 {
+		WebhookBackoffSecs = cfg.WebhookBackoffSecs
+	}
+	if cfg.ShutdownNatsSecs != 0 {
+		ShutdownNatsSecs = cfg.ShutdownNatsSecs
+	}
+	if cfg.ShutdownWritesSecs != 0 {
+		ShutdownWritesSecs = cfg.ShutdownWritesSecs
+	}
+	if cfg.ShutdownHTTPSecs != 0 {
+		ShutdownHTTPSecs = cfg.ShutdownHTTPSecs
+	}
 	if cfg.PostgresPWD != "" {
 		PostgresPWD = cfg.PostgresPWD
 	}
 	if cfg.NatsURL != "" {
 		NatsURL = cfg.NatsURL
 	}
-	fmt.Println("Http host:", HPServer)
-	fmt.Println("Nats host:", NatsURL)
-	fmt.Printf("Cache max size: %d\n", CacheSize)
-	fmt.Printf("Cache limit on time in seconds: %d\n", CacheTimeLimitSecs)
 	return nil
 }
+
+// LogConfig writes configuration parsed by ParseArgsServer
+func LogConfig(log *zap.SugaredLogger) {
+	fields := []any{
+		"http_host", HPServer,
+		"grpc_host", GRPCServer,
+		"admin_host", AdminServer,
+		"nats_host", NatsURL,
+		"log_level", LogLevel,
+		"log_format", LogFormat,
+		"log_preset", LogPreset,
+		"trace_exporter", TraceExporter,
+		"trace_path", TracePath,
+		"trace_sample_ratio", TraceSampleRatio,
+		"http_compression", HTTPCompression,
+		"http_compression_min_size", HTTPCompressionMinSize,
+		"http_route_timeouts", HTTPRouteTimeouts,
+		"http_read_timeout_secs", HTTPReadTimeoutSecs,
+		"http_read_header_timeout_secs", HTTPReadHeaderTimeoutSecs,
+		"http_write_timeout_secs", HTTPWriteTimeoutSecs,
+		"http_idle_timeout_secs", HTTPIdleTimeoutSecs,
+		"postgres_query_timeout_ms", PostgresQueryTimeoutMs,
+		"postgres_breaker_failures", PostgresBreakerFailures,
+		"postgres_breaker_open_secs", PostgresBreakerOpenSecs,
+		"postgres_breaker_probes", PostgresBreakerProbes,
+		"nats_message_timeout_secs", NatsMessageTimeoutSecs,
+		"access_log_format", AccessLogFormat,
+		"access_log_output", AccessLogOutput,
+		"access_log_max_size_mb", AccessLogMaxSizeMB,
+		"access_log_max_backups", AccessLogMaxBackups,
+		"access_log_sample_rate", AccessLogSampleRate,
+		"cache_type", CacheType,
+		"cache_size", CacheSize,
+		"cache_shards", CacheShards,
+	}
+	if CacheType == "bytes" {
+		fields = append(fields, "cache_max_bytes", CacheMaxBytes, "cache_max_entry_bytes", CacheMaxEntryBytes)
+	}
+	fields = append(fields,
+		"cache_time_limit_secs", CacheTimeLimitSecs,
+		"cache_soft_limit_secs", CacheSoftLimitSecs,
+		"cache_retention_secs", CacheRetentionSecs,
+		"cache_refresh_concurrency", CacheRefreshConcurrency,
+		"cache_invalidation_subject", CacheInvalidationSubject,
+		"cache_snapshot", CacheSnapshotPath,
+		"cache_warmup_strategy", CacheWarmupStrategy,
+		"cache_warmup_window_hours", CacheWarmupWindowHours,
+		"cache_warmup_concurrency", CacheWarmupConcurrency,
+		"cache_warmup_budget_secs", CacheWarmupBudgetSecs,
+		"cache_negative_size", CacheNegativeSize,
+		"cache_negative_time_limit_secs", CacheNegativeTimeLimitSecs,
+		"cache_bloom_expected", CacheBloomExpected,
+		"cache_bloom_false_positive", CacheBloomFalsePositive,
+		"cache_bloom_rebuild_secs", CacheBloomRebuildSecs,
+		"cache_encodings", CacheEncodings,
+		"stream_replay_size", StreamReplaySize,
+		"stream_allowed_origins", StreamAllowedOrigins,
+		"webhook_max_attempts", WebhookMaxAttempts,
+		"webhook_backoff_secs", WebhookBackoffSecs,
+		"shutdown_nats_secs", ShutdownNatsSecs,
+		"shutdown_writes_secs", ShutdownWritesSecs,
+		"shutdown_http_secs", ShutdownHTTPSecs,
+	)
+	log.Infow("Server configuration", fields...)
+}
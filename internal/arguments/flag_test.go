@@ -0,0 +1,32 @@
+package arguments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgsServer_EnvDisables(t *testing.T) {
+	t.Setenv("CACHE_NEGATIVE_SIZE", "0")
+	t.Setenv("POSTGRES_QUERY_TIMEOUT_MS", "0")
+	t.Setenv("POSTGRES_BREAKER_FAILURES", "0")
+	t.Setenv("STREAM_REPLAY_SIZE", "0")
+	t.Setenv("CACHE_SNAPSHOT_PATH", "")
+	t.Setenv("HTTP_COMPRESSION", "")
+	t.Setenv("CACHE_BLOOM_EXPECTED", "5000")
+	require.Equal(t, nil, ParseArgsServer())
+
+	assert.Equal(t, 0, CacheNegativeSize)
+	assert.Equal(t, 0, PostgresQueryTimeoutMs)
+	assert.Equal(t, 0, PostgresBreakerFailures)
+	assert.Equal(t, 0, StreamReplaySize)
+	assert.Equal(t, "", CacheSnapshotPath)
+	assert.Equal(t, "", HTTPCompression)
+	assert.Equal(t, 5000, CacheBloomExpected)
+	// unset variables keep defaults of flags
+	assert.Equal(t, 1, PostgresBreakerProbes)
+	assert.Equal(t, 1024, HTTPCompressionMinSize)
+	assert.Equal(t, 0.01, CacheBloomFalsePositive)
+	assert.Equal(t, 600, CacheBloomRebuildSecs)
+}
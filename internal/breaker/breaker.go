@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while calls are not let through
+var ErrOpen = errors.New("Circuit breaker is open")
+
+// States of breaker
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+type Config struct {
+	// Failures in a row which open breaker
+	Failures int
+	// OpenTimeout is time after which open breaker lets probes through
+	OpenTimeout time.Duration
+	// Probes are calls let through at the same time in half-open state,
+	// breaker is closed when all of them succeed and opened again on first failure
+	Probes int
+}
+
+// Breaker stops calls to dependency after Failures in a row, nil Breaker lets every call through
+type Breaker struct {
+	cfg Config
+	// OnChange is called with old and new state under lock of breaker, it must not call breaker
+	OnChange func(from, to string)
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probes    int
+	succeeded int
+	now       func() time.Time
+}
+
+func New(cfg Config) *Breaker {
+	if cfg.Failures < 1 {
+		cfg.Failures = 1
+	}
+	if cfg.Probes < 1 {
+		cfg.Probes = 1
+	}
+	return &Breaker{cfg: cfg, state: StateClosed, now: time.Now}
+}
+
+// Allow returns func which reports result of call, it has to be called once when call is let through
+func (b *Breaker) Allow() (func(failed bool), error) {
+	if b == nil {
+		return func(bool) {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	switch b.state {
+	case StateOpen:
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.cfg.Probes {
+			return nil, ErrOpen
+		}
+		b.probes++
+		return b.doneProbe, nil
+	}
+	return b.done, nil
+}
+
+func (b *Breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateClosed {
+		// result of call started before breaker was opened
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.cfg.Failures {
+		b.set(StateOpen)
+	}
+}
+
+func (b *Breaker) doneProbe(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateHalfOpen {
+		return
+	}
+	b.probes--
+	if failed {
+		b.set(StateOpen)
+		return
+	}
+	b.succeeded++
+	if b.succeeded >= b.cfg.Probes {
+		b.set(StateClosed)
+	}
+}
+
+// refresh moves open breaker to half-open state after OpenTimeout
+func (b *Breaker) refresh() {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.set(StateHalfOpen)
+	}
+}
+
+func (b *Breaker) set(state string) {
+	from := b.state
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.succeeded = 0
+	if state == StateOpen {
+		b.openedAt = b.now()
+	}
+	if b.OnChange != nil && from != state {
+		b.OnChange(from, state)
+	}
+}
+
+func (b *Breaker) State() string {
+	if b == nil {
+		return StateClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	return b.state
+}
+
+// RetryAfter is time left until probing of open breaker, 0 when calls are let through
+func (b *Breaker) RetryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh()
+	if b.state != StateOpen {
+		return 0
+	}
+	return b.cfg.OpenTimeout - b.now().Sub(b.openedAt)
+}
+
+// Wait blocks while breaker is open or ctx is not done
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		d := b.RetryAfter()
+		if d <= 0 {
+			return nil
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New(Config{Failures: 2, OpenTimeout: 10 * time.Second, Probes: 1})
+	b.now = func() time.Time { return now }
+	changes := make([]string, 0)
+	b.OnChange = func(from, to string) {
+		changes = append(changes, from+"->"+to)
+	}
+	call := func(failed bool) error {
+		done, err := b.Allow()
+		if err != nil {
+			return err
+		}
+		done(failed)
+		return nil
+	}
+
+	// success resets failures in a row
+	require.Equal(t, nil, call(true))
+	require.Equal(t, nil, call(false))
+	require.Equal(t, nil, call(true))
+	assert.Equal(t, StateClosed, b.State())
+	require.Equal(t, nil, call(true))
+	assert.Equal(t, StateOpen, b.State())
+	assert.ErrorIs(t, call(false), ErrOpen)
+	assert.Equal(t, 10*time.Second, b.RetryAfter())
+
+	// failed probe opens breaker again
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Equal(t, time.Duration(0), b.RetryAfter())
+	done, err := b.Allow()
+	require.Equal(t, nil, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrOpen, "only one probe at a time")
+	done(true)
+	assert.Equal(t, StateOpen, b.State())
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, b.RetryAfter())
+	now = now.Add(6 * time.Second)
+	require.Equal(t, nil, call(false))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed",
+	}, changes)
+}
+
+func TestBreaker_Nil(t *testing.T) {
+	var b *Breaker
+	done, err := b.Allow()
+	require.Equal(t, nil, err)
+	done(true)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, nil, b.Wait(context.Background()))
+}
+
+func TestBreaker_Wait(t *testing.T) {
+	b := New(Config{Failures: 1, OpenTimeout: 20 * time.Millisecond})
+	done, err := b.Allow()
+	require.Equal(t, nil, err)
+	done(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Wait(ctx), context.DeadlineExceeded)
+
+	start := time.Now()
+	require.Equal(t, nil, b.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
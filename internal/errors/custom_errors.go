@@ -1,8 +1,9 @@
 package customerrors
 
 import (
-	"fmt"
 	"net/http"
+
+	"go.uber.org/zap"
 )
 
 type CustomError struct {
@@ -18,6 +19,6 @@ func (e *CustomError) ReportError(w http.ResponseWriter) {
 	w.WriteHeader(int(e.Status))
 	status, err := w.Write([]byte(e.Message))
 	if err != nil {
-		fmt.Printf("Problem with writing to responser status is %d: %s\n", status, err.Error())
+		zap.S().Warnw("Problem with writing of error response", "status", e.Status, "written", status, "error", err)
 	}
 }
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/akashipov/L0project/internal/storage/order"
+)
+
+// SubscriberBuffer is a number of events kept for slow subscriber,
+// subscriber is closed when buffer is full, so it resumes from its last event
+const SubscriberBuffer = 64
+
+// DefaultReplaySize is a number of last events kept to resume streams
+const DefaultReplaySize = 256
+
+// Event is an order stored to Psql db with its sequence number, see PublishID
+type Event struct {
+	ID       uint64
+	StoredAt time.Time
+	Order    order.Order
+}
+
+// Summary is a compact view of event sent to dashboards
+type Summary struct {
+	ID              uint64  `json:"id"`
+	OrderID         string  `json:"order_uid"`
+	TrackNumber     string  `json:"track_number"`
+	Entry           string  `json:"entry"`
+	CustomerID      string  `json:"customer_id"`
+	DeliveryService string  `json:"delivery_service"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	ItemsCount      int     `json:"items_count"`
+	DateCreated     string  `json:"date_created"`
+	StoredAt        int64   `json:"stored_at"`
+}
+
+func (e *Event) Summary() Summary {
+	s := Summary{
+		ID:              e.ID,
+		OrderID:         e.Order.OrderID,
+		TrackNumber:     e.Order.TrackNumber,
+		Entry:           e.Order.Entry,
+		CustomerID:      e.Order.CustomerID,
+		DeliveryService: e.Order.DeliveryService,
+		ItemsCount:      len(e.Order.Items),
+		DateCreated:     e.Order.DateCreated,
+		StoredAt:        e.StoredAt.Unix(),
+	}
+	if e.Order.PaymentInfo != nil {
+		s.Amount = e.Order.PaymentInfo.Amount
+		s.Currency = e.Order.PaymentInfo.Currency
+	}
+	return s
+}
+
+// Filter keeps events which match all of non empty fields
+type Filter struct {
+	DeliveryService string
+	CustomerID      string
+	Entry           string
+}
+
+func (f Filter) Match(e *Event) bool {
+	if f.DeliveryService != "" && f.DeliveryService != e.Order.DeliveryService {
+		return false
+	}
+	if f.CustomerID != "" && f.CustomerID != e.Order.CustomerID {
+		return false
+	}
+	if f.Entry != "" && f.Entry != e.Order.Entry {
+		return false
+	}
+	return true
+}
+
+// Hub broadcasts orders stored to Psql db to all subscribers
+type Hub struct {
+	mu     sync.RWMutex
+	next   int
+	subs   map[int]chan Event
+	lastID uint64
+	// replay is a ring buffer of last events ordered by ID
+	replay     []Event
+	replayHead int
+	replaySize int
+}
+
+// Stored is fed by relay.Feed with orders committed by any replica
+var Stored = NewHub(DefaultReplaySize)
+
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		subs:       make(map[int]chan Event),
+		replay:     make([]Event, 0, replaySize),
+		replaySize: replaySize,
+	}
+}
+
+// Subscribe returns channel with new events and function to unsubscribe
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	_, ch, cancel := h.SubscribeFrom(math.MaxUint64)
+	return ch, cancel
+}
+
+// SubscribeFrom returns kept events with ID greater than lastID followed by
+// channel with new events, nothing is lost or duplicated between them.
+// Channel is closed when subscriber lags behind by SubscriberBuffer events
+func (h *Hub) SubscribeFrom(lastID uint64) ([]Event, <-chan Event, func()) {
+	r, ch, cancel := h.Resume(lastID)
+	return r.Missed, ch, cancel
+}
+
+// Replay is what subscriber resuming from its last event gets before new events
+type Replay struct {
+	Missed []Event
+	// Gap is set when some of events after last id are not kept or the id is unknown to hub,
+	// e.g. it is from before stream was recreated. Subscriber has to reload orders
+	Gap bool
+	// LastID is ID of the last event published before subscription
+	LastID uint64
+}
+
+// Resume works like SubscribeFrom and tells whether kept events continue lastID
+func (h *Hub) Resume(lastID uint64) (Replay, <-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := Replay{Missed: make([]Event, 0), LastID: h.lastID}
+	for i := 0; i < len(h.replay); i++ {
+		e := h.replay[(h.replayHead+i)%len(h.replay)]
+		if e.ID > lastID {
+			r.Missed = append(r.Missed, e)
+		}
+	}
+	r.Gap = lastID != h.lastID
+	if lastID < h.lastID && len(h.replay) > 0 {
+		r.Gap = h.replay[h.replayHead].ID > lastID+1
+	}
+	id := h.next
+	h.next++
+	ch := make(chan Event, SubscriberBuffer)
+	h.subs[id] = ch
+	var once sync.Once
+	return r, ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			// lagging subscriber is already closed by Publish
+			if _, ok := h.subs[id]; ok {
+				delete(h.subs, id)
+				close(ch)
+			}
+		})
+	}
+}
+
+func (h *Hub) LastID() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lastID
+}
+
+// Publish gives order next ID. It never blocks and returns number of subscribers
+// closed because of full buffer, dropping event silently would leave a gap which resume can't detect
+func (h *Hub) Publish(ord order.Order) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.publish(h.lastID+1, ord)
+}
+
+// PublishID works like Publish with durable ID of event, e.g. sequence of JetStream stream,
+// so subscriber resumes on any replica and after restart. ID not greater than the last one
+// means that IDs started again, kept events are dropped then
+func (h *Hub) PublishID(id uint64, ord order.Order) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id <= h.lastID {
+		h.replay = h.replay[:0]
+		h.replayHead = 0
+	}
+	return h.publish(id, ord)
+}
+
+func (h *Hub) publish(id uint64, ord order.Order) int {
+	h.lastID = id
+	e := Event{ID: id, StoredAt: time.Now(), Order: ord}
+	if h.replaySize > 0 {
+		if len(h.replay) < h.replaySize {
+			h.replay = append(h.replay, e)
+		} else {
+			h.replay[h.replayHead] = e
+			h.replayHead = (h.replayHead + 1) % h.replaySize
+		}
+	}
+	closed := 0
+	for id, ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, id)
+			close(ch)
+			closed++
+		}
+	}
+	return closed
+}
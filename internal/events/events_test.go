@@ -0,0 +1,114 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOrder(id, service string) order.Order {
+	ord := order.NewOrder()
+	ord.OrderID = id
+	ord.DeliveryService = service
+	return ord
+}
+
+func TestHub_SubscribeFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		lastID uint64
+		want   []string
+		gap    bool
+	}{
+		{name: "older_than_buffer", lastID: 0, want: []string{"3", "4", "5"}, gap: true},
+		{name: "oldest_kept", lastID: 2, want: []string{"3", "4", "5"}},
+		{name: "inside_buffer", lastID: 3, want: []string{"4", "5"}},
+		{name: "up_to_date", lastID: 5, want: []string{}},
+		// id from before restart of stream
+		{name: "unknown", lastID: 9, want: []string{}, gap: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(3)
+			for _, id := range []string{"1", "2", "3", "4", "5"} {
+				h.Publish(newOrder(id, "meest"))
+			}
+			replay, ch, cancel := h.Resume(tt.lastID)
+			defer cancel()
+			got := make([]string, 0)
+			for _, e := range replay.Missed {
+				got = append(got, e.Order.OrderID)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.gap, replay.Gap)
+			assert.Equal(t, uint64(5), replay.LastID)
+			h.Publish(newOrder("new", "meest"))
+			e := <-ch
+			assert.Equal(t, "new", e.Order.OrderID)
+		})
+	}
+}
+
+func TestHub_PublishID(t *testing.T) {
+	h := NewHub(3)
+	h.PublishID(10, newOrder("a", "meest"))
+	h.PublishID(11, newOrder("b", "meest"))
+	replay, _, cancel := h.Resume(10)
+	cancel()
+	assert.False(t, replay.Gap)
+	require.Equal(t, 1, len(replay.Missed))
+	assert.Equal(t, uint64(11), replay.Missed[0].ID)
+	// events published before hub was started are unknown to it
+	replay, _, cancel = h.Resume(5)
+	cancel()
+	assert.True(t, replay.Gap)
+
+	// stream was recreated, kept events of old one are dropped
+	h.PublishID(1, newOrder("c", "meest"))
+	assert.Equal(t, uint64(1), h.LastID())
+	replay, _, cancel = h.Resume(11)
+	cancel()
+	assert.True(t, replay.Gap)
+	require.Equal(t, 0, len(replay.Missed))
+	replay, _, cancel = h.Resume(0)
+	cancel()
+	assert.False(t, replay.Gap)
+	require.Equal(t, 1, len(replay.Missed))
+	assert.Equal(t, "c", replay.Missed[0].Order.OrderID)
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub(SubscriberBuffer + 1)
+	ch, cancel := h.Subscribe()
+	defer cancel()
+	for i := 0; i < SubscriberBuffer; i++ {
+		assert.Equal(t, 0, h.Publish(newOrder("id", "meest")))
+	}
+	assert.Equal(t, 1, h.Publish(newOrder("lost", "meest")))
+	assert.Equal(t, 0, h.Publish(newOrder("next", "meest")))
+	var last uint64
+	for e := range ch {
+		last = e.ID
+	}
+	assert.Equal(t, uint64(SubscriberBuffer), last)
+
+	// subscriber resumes from its last event and gets everything after it
+	missed, _, cancelResumed := h.SubscribeFrom(last)
+	defer cancelResumed()
+	require.Equal(t, 2, len(missed))
+	assert.Equal(t, "lost", missed[0].Order.OrderID)
+	assert.Equal(t, "next", missed[1].Order.OrderID)
+}
+
+func TestFilter_Match(t *testing.T) {
+	e := Event{Order: newOrder("1", "meest")}
+	e.Order.CustomerID = "test"
+	e.Order.Entry = "WBIL"
+	assert.True(t, Filter{}.Match(&e))
+	assert.True(t, Filter{DeliveryService: "meest", CustomerID: "test", Entry: "WBIL"}.Match(&e))
+	assert.False(t, Filter{DeliveryService: "dhl"}.Match(&e))
+	assert.False(t, Filter{CustomerID: "other"}.Match(&e))
+	assert.False(t, Filter{Entry: "OTHER"}.Match(&e))
+}
@@ -0,0 +1,63 @@
+package grpcserver
+
+import (
+	"github.com/akashipov/L0project/internal/api/orderpb"
+	"github.com/akashipov/L0project/internal/storage/order"
+)
+
+func ToProto(ord *order.Order) *orderpb.Order {
+	res := &orderpb.Order{
+		OrderUid:          ord.OrderID,
+		TrackNumber:       ord.TrackNumber,
+		Entry:             ord.Entry,
+		Locale:            ord.Locale,
+		InternalSignature: ord.InternalSignature,
+		CustomerId:        ord.CustomerID,
+		DeliveryService:   ord.DeliveryService,
+		Shardkey:          ord.ShardKey,
+		SmId:              ord.SmID,
+		DateCreated:       ord.DateCreated,
+		OofShard:          ord.OofShard,
+	}
+	if ord.User != nil {
+		res.Delivery = &orderpb.Delivery{
+			Name:    ord.User.Name,
+			Phone:   ord.User.Phonenumber,
+			Email:   ord.User.Email,
+			Zip:     ord.User.Zipcode,
+			City:    ord.User.City,
+			Address: ord.User.Address.Address,
+			Region:  ord.User.Region,
+		}
+	}
+	if ord.PaymentInfo != nil {
+		res.Payment = &orderpb.Payment{
+			Transaction:  ord.PaymentInfo.TransactionID,
+			RequestId:    ord.PaymentInfo.RequestID,
+			Currency:     ord.PaymentInfo.Currency,
+			Provider:     ord.PaymentInfo.ProviderID,
+			Amount:       ord.PaymentInfo.Amount,
+			PaymentDt:    ord.PaymentInfo.PaymentDateTime,
+			Bank:         ord.PaymentInfo.Bank,
+			DeliveryCost: ord.PaymentInfo.DeliveryCost,
+			GoodsTotal:   ord.PaymentInfo.GoodsTotal,
+			CustomFee:    ord.PaymentInfo.CustomFee,
+		}
+	}
+	for _, itm := range ord.Items {
+		res.Items = append(res.Items, &orderpb.Item{
+			ChrtId:      itm.ChrtID,
+			TrackNumber: itm.TrackNumber,
+			Price:       itm.Price,
+			Rid:         itm.RID,
+			Name:        itm.Name,
+			Sale:        int64(itm.Sale),
+			Size:        itm.Size,
+			TotalPrice:  itm.TotalPrice,
+			NmId:        int64(itm.NmID),
+			Brand:       itm.Brand,
+			Status:      int64(itm.Status),
+		})
+	}
+	return res
+}
@@ -0,0 +1,201 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/akashipov/L0project/internal/api/orderpb"
+	"github.com/akashipov/L0project/internal/arguments"
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	MaxBatchSize    = 100
+)
+
+type OrderService struct {
+	orderpb.UnimplementedOrderServiceServer
+	Log *zap.SugaredLogger
+	// Stopping ends watchers when server stops, GracefulStop would wait for them
+	Stopping chan struct{}
+}
+
+type Server struct {
+	Srv      *grpc.Server
+	Log      *zap.SugaredLogger
+	stopping chan struct{}
+}
+
+func NewServer(log *zap.SugaredLogger) *Server {
+	stopping := make(chan struct{})
+	srv := grpc.NewServer()
+	orderpb.RegisterOrderServiceServer(srv, &OrderService{Log: log, Stopping: stopping})
+	reflection.Register(srv)
+	return &Server{Srv: srv, Log: log, stopping: stopping}
+}
+
+func (s *Server) RunServer(done chan struct{}, w *sync.WaitGroup) {
+	defer w.Done()
+	lis, err := net.Listen("tcp", arguments.GRPCServer)
+	if err != nil {
+		s.Log.Errorw("Problem with listening of grpc address", "address", arguments.GRPCServer, "error", err)
+		return
+	}
+	go func() {
+		err := s.Srv.Serve(lis)
+		if err != nil {
+			s.Log.Errorw("Grpc server error", "error", err)
+		}
+	}()
+	<-done
+	timeout := time.Duration(arguments.ShutdownHTTPSecs) * time.Second
+	s.Log.Infow("Grpc server is stopping...", "timeout", timeout.String())
+	s.Stop(timeout)
+	s.Log.Infof("Grpc server is stopped")
+}
+
+// Stop ends watchers and waits for other calls until timeout, then connections are closed
+func (s *Server) Stop(timeout time.Duration) {
+	close(s.stopping)
+	stopped := make(chan struct{})
+	go func() {
+		s.Srv.GracefulStop()
+		close(stopped)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-stopped:
+	case <-t.C:
+		s.Log.Warnw("Grpc calls are not finished in time, connections are closed", "timeout", timeout.String())
+		s.Srv.Stop()
+		<-stopped
+	}
+}
+
+// statusFromCustom maps http status of storage error to grpc code
+func statusFromCustom(cErr *customerrors.CustomError) error {
+	code := codes.Internal
+	switch int(cErr.Status) {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, cErr.Message)
+}
+
+func (s *OrderService) loadOrder(ctx context.Context, id string) (*orderpb.Order, *customerrors.CustomError) {
+	data, cErr := cache.GetOrder(ctx, id)
+	if cErr != nil {
+		return nil, cErr
+	}
+	var ord order.Order
+	err := json.Unmarshal(data, &ord)
+	if err != nil {
+		return nil, &customerrors.CustomError{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		}
+	}
+	return ToProto(&ord), nil
+}
+
+func (s *OrderService) GetOrder(ctx context.Context, req *orderpb.GetOrderRequest) (*orderpb.Order, error) {
+	if req.GetOrderUid() == "" {
+		return nil, status.Error(codes.InvalidArgument, "order_uid is empty")
+	}
+	ord, cErr := s.loadOrder(ctx, req.GetOrderUid())
+	if cErr != nil {
+		return nil, statusFromCustom(cErr)
+	}
+	return ord, nil
+}
+
+func (s *OrderService) BatchGetOrders(ctx context.Context, req *orderpb.BatchGetOrdersRequest) (*orderpb.BatchGetOrdersResponse, error) {
+	if len(req.GetOrderUids()) > MaxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "batch size is more than %d", MaxBatchSize)
+	}
+	resp := &orderpb.BatchGetOrdersResponse{}
+	for _, id := range req.GetOrderUids() {
+		ord, cErr := s.loadOrder(ctx, id)
+		if cErr != nil {
+			if int(cErr.Status) == http.StatusNotFound {
+				resp.NotFound = append(resp.NotFound, id)
+				continue
+			}
+			return nil, statusFromCustom(cErr)
+		}
+		resp.Orders = append(resp.Orders, ord)
+	}
+	return resp, nil
+}
+
+func (s *OrderService) ListOrders(ctx context.Context, req *orderpb.ListOrdersRequest) (*orderpb.ListOrdersResponse, error) {
+	size := int(req.GetPageSize())
+	if size < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size is negative")
+	}
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	ids, err := postgres.DBWorker.ListOrderIDs(ctx, nil, req.GetPageToken(), size)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &orderpb.ListOrdersResponse{}
+	for _, id := range ids {
+		ord, cErr := s.loadOrder(ctx, id)
+		if cErr != nil {
+			return nil, statusFromCustom(cErr)
+		}
+		resp.Orders = append(resp.Orders, ord)
+	}
+	if len(ids) == size {
+		resp.NextPageToken = ids[len(ids)-1]
+	}
+	return resp, nil
+}
+
+func (s *OrderService) WatchOrders(req *orderpb.WatchOrdersRequest, stream orderpb.OrderService_WatchOrdersServer) error {
+	orders, unsubscribe := events.Stored.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-s.Stopping:
+			return status.Error(codes.Unavailable, "server is stopping, watch again")
+		case e, ok := <-orders:
+			if !ok {
+				return status.Error(codes.Unavailable, "watcher lagged behind stored orders, watch again")
+			}
+			err := stream.Send(ToProto(&e.Order))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
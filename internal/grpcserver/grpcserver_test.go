@@ -0,0 +1,110 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/api/orderpb"
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestStatusFromCustom(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		code   codes.Code
+	}{
+		{name: "not_found", status: http.StatusNotFound, code: codes.NotFound},
+		{name: "bad_request", status: http.StatusBadRequest, code: codes.InvalidArgument},
+		{name: "unavailable", status: http.StatusServiceUnavailable, code: codes.Unavailable},
+		{name: "internal", status: http.StatusInternalServerError, code: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := statusFromCustom(&customerrors.CustomError{Message: "msg", Status: http.ConnState(tt.status)})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestWatchOrders(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(zap.NewNop().Sugar())
+	go srv.Srv.Serve(lis)
+	defer srv.Srv.Stop()
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.Equal(t, nil, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := orderpb.NewOrderServiceClient(conn).WatchOrders(ctx, &orderpb.WatchOrdersRequest{})
+	require.Equal(t, nil, err)
+
+	b, err := postgres.Read("/statics/test/order.json")
+	require.Equal(t, nil, err)
+	var ord order.Order
+	err = json.Unmarshal([]byte(b), &ord)
+	require.Equal(t, nil, err)
+	// subscription is created on the server side asynchronously
+	go func() {
+		for ctx.Err() == nil {
+			events.Stored.Publish(ord)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	got, err := stream.Recv()
+	require.Equal(t, nil, err)
+	assert.Equal(t, ord.OrderID, got.GetOrderUid())
+	assert.Equal(t, ord.User.Phonenumber, got.GetDelivery().GetPhone())
+	assert.Equal(t, ord.PaymentInfo.TransactionID, got.GetPayment().GetTransaction())
+	assert.Equal(t, len(ord.Items), len(got.GetItems()))
+}
+
+func TestServer_Stop(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(zap.NewNop().Sugar())
+	go srv.Srv.Serve(lis)
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.Equal(t, nil, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := orderpb.NewOrderServiceClient(conn).WatchOrders(ctx, &orderpb.WatchOrdersRequest{})
+	require.Equal(t, nil, err)
+	// watcher started before or after stop ends at once
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	srv.Stop(5 * time.Second)
+	assert.Less(t, time.Since(start), time.Second)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/akashipov/L0project/internal/pkg/middleware/compress"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWriteEntry(t *testing.T) {
+	prev := cache.Encodings
+	defer func() { cache.Encodings = prev }()
+	cache.Encodings = []string{cache.EncodingBrotli, cache.EncodingGzip}
+	e := cache.Entry{
+		Data:    []byte(`{"order_uid": "b563feb7b2b84b6test"}`),
+		Encoded: map[string][]byte{cache.EncodingGzip: []byte("gzipped"), cache.EncodingBrotli: []byte("brotli")},
+	}
+	cfg := compress.Config{Encodings: []string{compress.Gzip}, MinSize: 0}
+	h := compress.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeEntry(w, r, e)
+	}), cfg, zap.NewNop().Sugar())
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{name: "identity", acceptEncoding: "", wantEncoding: "", wantBody: string(e.Data)},
+		{name: "gzip", acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: "gzipped"},
+		{name: "brotli_preferred", acceptEncoding: "gzip, br", wantEncoding: "br", wantBody: "brotli"},
+		{name: "q_values", acceptEncoding: "gzip;q=1, br;q=0.2", wantEncoding: "gzip", wantBody: "gzipped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/order/b563feb7b2b84b6test", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			res := rec.Result()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tt.wantEncoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+			assert.Equal(t, []string{"Accept-Encoding"}, res.Header.Values("Vary"))
+			assert.Equal(t, strconv.Itoa(len(tt.wantBody)), res.Header.Get("Content-Length"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
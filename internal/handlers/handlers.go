@@ -1,62 +1,120 @@
 package handlers
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
+	"expvar"
 	"net/http"
+	"strconv"
 	"time"
 
-	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/arguments"
+	"github.com/akashipov/L0project/internal/health"
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/akashipov/L0project/internal/openapi"
 	"github.com/akashipov/L0project/internal/pkg/middleware/compress"
 	"github.com/akashipov/L0project/internal/pkg/middleware/logger"
+	"github.com/akashipov/L0project/internal/pkg/middleware/timeout"
 	"github.com/akashipov/L0project/internal/storage/cache"
 	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/tracing"
+	"github.com/akashipov/L0project/internal/ui"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 func ServerRouter(log *zap.SugaredLogger) http.Handler {
+	encodings, err := compress.ParseEncodings(arguments.HTTPCompression)
+	if err != nil {
+		log.Warnf("Problem with http compression, '%s' is used: %s", compress.Gzip, err.Error())
+		encodings = []string{compress.Gzip}
+	}
+	cfg := compress.Config{Encodings: encodings, MinSize: arguments.HTTPCompressionMinSize}
+	accessCfg := logger.AccessConfig{
+		Format:     arguments.AccessLogFormat,
+		Output:     arguments.AccessLogOutput,
+		MaxBytes:   int64(arguments.AccessLogMaxSizeMB) << 20,
+		MaxBackups: arguments.AccessLogMaxBackups,
+		SampleRate: arguments.AccessLogSampleRate,
+	}
+	access, err := logger.NewAccessLog(accessCfg)
+	if err != nil {
+		log.Warnf("Problem with access log, json to stdout is used: %s", err.Error())
+		access, _ = logger.NewAccessLog(logger.AccessConfig{Format: logger.FormatJSON, SampleRate: 1})
+	}
+	return logger.RequestID(access.Handle(tracing.Handle(metrics.Handle(compress.Handle(Routes(log), cfg, log)))))
+}
+
+// Routes registers every route of the server, each of them
+// has to be described by openapi.Spec
+func Routes(log *zap.SugaredLogger) chi.Router {
+	deadlines, err := timeout.ParseRoutes(arguments.HTTPRouteTimeouts)
+	if err != nil {
+		log.Warnf("Problem with http route timeouts, requests have no deadline: %s", err.Error())
+		deadlines = timeout.Routes{}
+	}
 	r := chi.NewRouter()
-	r.Get(
-		"/order/{id}",
-		logger.WithLogging(http.HandlerFunc(GetOrder), log),
-	)
-	return compress.GzipHandle(r, log)
+	r.With(deadlines.Handle("/order/{id}")).Get("/order/{id}", GetOrder(log))
+	// streams are open while client is connected
+	r.Get("/orders/stream", OrdersStream)
+	r.Get("/orders/ws", OrdersWebSocket(ParseOrigins(arguments.StreamAllowedOrigins)))
+	r.With(deadlines.Handle("/ui")).Mount("/ui", ui.NewUI(log).Router())
+	r.With(deadlines.Handle("/debug/vars")).Method(http.MethodGet, "/debug/vars", expvar.Handler())
+	r.With(deadlines.Handle("/metrics")).Method(http.MethodGet, "/metrics", metrics.Handler())
+	r.Get("/healthz", health.Default.Liveness)
+	r.With(deadlines.Handle("/readyz")).Get("/readyz", health.Default.Readiness)
+	r.Get("/openapi.json", openapi.SpecHandler)
+	r.Get("/docs", openapi.DocsHandler)
+	return r
 }
 
-func GetOrder(w http.ResponseWriter, request *http.Request) {
-	id := chi.URLParam(request, "id")
-	t := time.Now().Unix()
-	ctx := context.Background()
-	v, ok := cache.LRUCache.Get(id)
-	if ok {
-		err := postgres.DBWorker.AddOrderHistory(ctx, nil, id, t)
+func GetOrder(log *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		id := chi.URLParam(request, "id")
+		t := time.Now().Unix()
+		e, lookup, cErr := cache.LookupOrder(request.Context(), id)
+		w.Header().Set(logger.CacheHeader, lookup)
+		if cErr != nil {
+			if cErr.Status == http.StatusServiceUnavailable {
+				w.Header().Set("Retry-After", retryAfter(postgres.DBWorker.Breaker.RetryAfter()))
+			}
+			cErr.ReportError(w)
+			return
+		}
+		writeEntry(w, request, e)
+		// history is written even when client is gone, only span of request is kept
+		err := postgres.DBWorker.AddOrderHistory(tracing.Detach(request.Context()), nil, id, t)
 		if err != nil {
-			fmt.Println("Problem with using of cache: " + err.Error())
+			log.Warnw("Problem with filling of history", "order_id", id, "error", err)
 		}
-		cache.LRUCache.Add(id, v)
-		w.Write(v)
-		return
 	}
-	ord, cErr := postgres.DBWorker.GetDataByID(ctx, id)
-	if cErr != nil {
-		cErr.ReportError(w)
-		return
+}
+
+// retryAfter is value of Retry-After header in whole seconds, at least one
+func retryAfter(d time.Duration) string {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
 	}
-	data, err := json.MarshalIndent(ord, "", "    ")
-	if err != nil {
-		cErr = &customerrors.CustomError{
-			Message: err.Error(),
-			Status:  http.StatusInternalServerError,
+	return strconv.Itoa(secs)
+}
+
+// writeEntry sends variant of order compressed in advance when client accepts it,
+// so compression middleware passes it through as is
+func writeEntry(w http.ResponseWriter, r *http.Request, e cache.Entry) {
+	offered := make([]string, 0, len(e.Encoded))
+	for _, enc := range cache.Encodings {
+		if _, ok := e.Encoded[enc]; ok {
+			offered = append(offered, enc)
 		}
-		cErr.ReportError(w)
-		return
 	}
-	cache.LRUCache.Add(id, data)
-	w.Write(data)
-	err = postgres.DBWorker.AddOrderHistory(ctx, nil, id, t)
-	if err != nil {
-		fmt.Println("Problem with filling of cache: " + err.Error())
+	body := e.Data
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	compress.AddVary(h)
+	enc := compress.Negotiate(r.Header.Get("Accept-Encoding"), offered)
+	if enc != "" {
+		body = e.Encoded[enc]
+		h.Set("Content-Encoding", enc)
 	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
 }
@@ -2,20 +2,25 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/akashipov/L0project/internal/breaker"
 	"github.com/akashipov/L0project/internal/storage/cache"
 	"github.com/akashipov/L0project/internal/storage/order"
 	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func PublishNats(data []byte) error {
@@ -87,3 +92,45 @@ func TestGetOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestGetOrder_Unavailable(t *testing.T) {
+	prevCache, prevBreaker := cache.LRUCache, postgres.DBWorker.Breaker
+	t.Cleanup(func() {
+		cache.LRUCache, postgres.DBWorker.Breaker = prevCache, prevBreaker
+	})
+	cache.LRUCache = cache.NewExpirableLRU(10, time.Minute)
+	postgres.DBWorker.Breaker = breaker.New(breaker.Config{Failures: 1, OpenTimeout: 90 * time.Second})
+	done, err := postgres.DBWorker.Breaker.Allow()
+	require.Equal(t, nil, err)
+	done(true)
+
+	r := chi.NewRouter()
+	r.Get("/order/{id}", GetOrder(zap.NewNop().Sugar()))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order/b563feb7b2b84b6test", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "90", rec.Header().Get("Retry-After"))
+	assert.Equal(t, cache.LookupMiss, rec.Header().Get("X-Cache"))
+}
+
+func TestGetOrder_HistoryFails(t *testing.T) {
+	prevCache, prevDB, prevBreaker := cache.LRUCache, postgres.DBWorker.DB, postgres.DBWorker.Breaker
+	t.Cleanup(func() {
+		cache.LRUCache, postgres.DBWorker.DB, postgres.DBWorker.Breaker = prevCache, prevDB, prevBreaker
+	})
+	cache.LRUCache = cache.NewExpirableLRU(10, time.Minute)
+	cache.LRUCache.Set("b563feb7b2b84b6cached", cache.Entry{Data: []byte(`{"order_uid":"b563feb7b2b84b6cached"}`), StoredAt: time.Now()})
+	// nothing listens there, so history of cache hit is not written
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	require.Equal(t, nil, err)
+	defer db.Close()
+	postgres.DBWorker.DB, postgres.DBWorker.Breaker = db, nil
+
+	r := chi.NewRouter()
+	r.Get("/order/{id}", GetOrder(zap.NewNop().Sugar()))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/order/b563feb7b2b84b6cached", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, cache.LookupHit, rec.Header().Get("X-Cache"))
+	assert.JSONEq(t, `{"order_uid":"b563feb7b2b84b6cached"}`, rec.Body.String())
+}
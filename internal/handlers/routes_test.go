@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/akashipov/L0project/internal/openapi"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	registered := make(map[string]bool)
+	err := chi.Walk(
+		Routes(zap.NewNop().Sugar()),
+		func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			registered[method+" "+openapi.PathFromRoute(route)] = true
+			return nil
+		},
+	)
+	require.Equal(t, nil, err)
+	described := make(map[string]bool)
+	for path, item := range openapi.Spec().Paths {
+		for method, op := range item.Operations() {
+			described[method+" "+path] = true
+			for _, param := range op.Parameters {
+				if param.In == "path" {
+					assert.True(t, strings.Contains(path, "{"+param.Name+"}"), "%s %s: unknown path parameter '%s'", method, path, param.Name)
+				}
+			}
+		}
+	}
+	for route := range registered {
+		assert.True(t, described[route], "route '%s' is not described in openapi spec", route)
+	}
+	for route := range described {
+		assert.True(t, registered[route], "route '%s' from openapi spec is not registered", route)
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/gorilla/websocket"
+)
+
+// HeartbeatInterval keeps idle streams alive behind proxies
+const HeartbeatInterval = 15 * time.Second
+
+const wsWriteTimeout = 10 * time.Second
+
+// closing is closed once, streams select on ch to end when server shuts down
+type closing struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newClosing() *closing {
+	return &closing{ch: make(chan struct{})}
+}
+
+func (c *closing) close() {
+	c.once.Do(func() { close(c.ch) })
+}
+
+// streams is closed by CloseStreams, Shutdown of http server would wait for open
+// streams until timeout and doesn't track hijacked websockets at all
+var streams = newClosing()
+
+// CloseStreams ends open streams, it is registered by RegisterOnShutdown of http server
+func CloseStreams() {
+	streams.close()
+}
+
+// ParseOrigins splits comma separated origins like 'https://shop.example'
+func ParseOrigins(s string) []string {
+	origins := make([]string, 0)
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/")
+		if o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// checkOrigin lets through clients without Origin header, pages of the same host
+// and allowed origins, other pages could use cookies of user to read stream
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return allowed[strings.TrimSuffix(strings.ToLower(origin), "/")]
+	}
+}
+
+func filterFromRequest(r *http.Request) events.Filter {
+	q := r.URL.Query()
+	return events.Filter{
+		DeliveryService: q.Get("delivery_service"),
+		CustomerID:      q.Get("customer_id"),
+		Entry:           q.Get("entry"),
+	}
+}
+
+// lastEventID returns id to resume from, the header is set by EventSource on reconnect,
+// query parameter is used by clients which can't set headers e.g. browser WebSocket
+func lastEventID(r *http.Request) (uint64, bool, *customerrors.CustomError) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, &customerrors.CustomError{
+			Message: fmt.Sprintf("Bad last event id '%s': %s", v, err.Error()),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	return id, true, nil
+}
+
+func subscribe(r *http.Request) (events.Replay, <-chan events.Event, func(), *customerrors.CustomError) {
+	id, ok, cErr := lastEventID(r)
+	if cErr != nil {
+		return events.Replay{}, nil, nil, cErr
+	}
+	if !ok {
+		ch, cancel := events.Stored.Subscribe()
+		return events.Replay{}, ch, cancel, nil
+	}
+	replay, ch, cancel := events.Stored.Resume(id)
+	return replay, ch, cancel, nil
+}
+
+// reset is sent before missed orders when some of orders after last event id of client are lost,
+// e.g. the id is from before restart of stream or older than kept orders. Client has to reload orders
+type reset struct {
+	Reset       bool   `json:"reset"`
+	LastEventID uint64 `json:"last_event_id"`
+}
+
+// newReset tells id the stream continues from
+func newReset(replay *events.Replay) reset {
+	id := replay.LastID
+	if len(replay.Missed) > 0 {
+		id = replay.Missed[0].ID - 1
+	}
+	return reset{Reset: true, LastEventID: id}
+}
+
+func OrdersStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		cErr := customerrors.CustomError{
+			Message: "Streaming is not supported",
+			Status:  http.StatusInternalServerError,
+		}
+		cErr.ReportError(w)
+		return
+	}
+	filter := filterFromRequest(r)
+	replay, ch, cancel, cErr := subscribe(r)
+	if cErr != nil {
+		cErr.ReportError(w)
+		return
+	}
+	defer cancel()
+	stopping := streams.ch
+	// stream outlives write timeout of server, writers of middlewares are unwrapped by controller
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	send := func(e *events.Event) error {
+		if !filter.Match(e) {
+			return nil
+		}
+		data, err := json.Marshal(e.Summary())
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", e.ID, data)
+		return err
+	}
+	if replay.Gap {
+		rs := newReset(&replay)
+		data, err := json.Marshal(rs)
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: reset\ndata: %s\n\n", rs.LastEventID, data)
+		if err != nil {
+			return
+		}
+	}
+	for i := range replay.Missed {
+		if send(&replay.Missed[i]) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		// EventSource reconnects with Last-Event-ID to other replica
+		case <-stopping:
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": ping\n\n")
+			if err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			// client lagged behind, EventSource reconnects with Last-Event-ID
+			if !ok {
+				return
+			}
+			if send(&e) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func OrdersWebSocket(origins []string) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     checkOrigin(origins),
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ordersWebSocket(upgrader, w, r)
+	}
+}
+
+func ordersWebSocket(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	filter := filterFromRequest(r)
+	replay, ch, cancel, cErr := subscribe(r)
+	if cErr != nil {
+		cErr.ReportError(w)
+		return
+	}
+	defer cancel()
+	stopping := streams.ch
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has already replied with error
+		return
+	}
+	defer conn.Close()
+
+	// reader detects close frames and dead connections, messages from client are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	send := func(e *events.Event) error {
+		if !filter.Match(e) {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(e.Summary())
+	}
+	if replay.Gap {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if conn.WriteJSON(newReset(&replay)) != nil {
+			return
+		}
+	}
+	for i := range replay.Missed {
+		if send(&replay.Missed[i]) != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-stopping:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down, resume from last event")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+			return
+		case <-heartbeat.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			// client lagged behind, it has to reconnect with last_event_id
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream lagged behind, resume from last event")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if send(&e) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func storedOrder(id, service string) order.Order {
+	ord := order.NewOrder()
+	ord.OrderID = id
+	ord.DeliveryService = service
+	return ord
+}
+
+func TestOrdersStream(t *testing.T) {
+	events.Stored = events.NewHub(events.DefaultReplaySize)
+	srv := httptest.NewServer(ServerRouter(zap.NewNop().Sugar()))
+	defer srv.Close()
+	events.Stored.Publish(storedOrder("first", "meest"))
+	last := events.Stored.LastID()
+	events.Stored.Publish(storedOrder("missed", "meest"))
+	events.Stored.Publish(storedOrder("other", "dhl"))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/orders/stream?delivery_service=meest", nil)
+	require.Equal(t, nil, err)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(last, 10))
+	res, err := http.DefaultClient.Do(req)
+	require.Equal(t, nil, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		events.Stored.Publish(storedOrder("skipped", "dhl"))
+		events.Stored.Publish(storedOrder("live", "meest"))
+	}()
+	got := make([]string, 0)
+	scanner := bufio.NewScanner(res.Body)
+	for len(got) < 2 && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var s events.Summary
+		require.Equal(t, nil, json.Unmarshal([]byte(data), &s))
+		got = append(got, s.OrderID)
+	}
+	assert.Equal(t, []string{"missed", "live"}, got)
+}
+
+func TestOrdersWebSocket(t *testing.T) {
+	events.Stored = events.NewHub(events.DefaultReplaySize)
+	srv := httptest.NewServer(ServerRouter(zap.NewNop().Sugar()))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/orders/ws?delivery_service=meest&last_event_id=0"
+	events.Stored.Publish(storedOrder("replayed", "meest"))
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Accept-Encoding": {"gzip"}})
+	require.Equal(t, nil, err)
+	defer conn.Close()
+	events.Stored.Publish(storedOrder("skipped", "dhl"))
+	events.Stored.Publish(storedOrder("live", "meest"))
+
+	got := make([]string, 0)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < 2 {
+		var s events.Summary
+		require.Equal(t, nil, conn.ReadJSON(&s))
+		got = append(got, s.OrderID)
+	}
+	assert.Equal(t, []string{"replayed", "live"}, got)
+}
+
+func TestOrdersWebSocket_Origin(t *testing.T) {
+	events.Stored = events.NewHub(events.DefaultReplaySize)
+	srv := httptest.NewServer(OrdersWebSocket(ParseOrigins(" https://shop.example/ ,")))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	tests := []struct {
+		name   string
+		origin string
+		status int
+	}{
+		{name: "no_origin", origin: "", status: http.StatusSwitchingProtocols},
+		{name: "same_origin", origin: srv.URL, status: http.StatusSwitchingProtocols},
+		{name: "allowed", origin: "https://Shop.example", status: http.StatusSwitchingProtocols},
+		{name: "cross_site", origin: "https://evil.example", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.origin != "" {
+				h.Set("Origin", tt.origin)
+			}
+			conn, res, err := websocket.DefaultDialer.Dial(url, h)
+			if conn != nil {
+				conn.Close()
+			}
+			require.NotEqual(t, nil, res, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
+
+func TestStreams_Shutdown(t *testing.T) {
+	events.Stored = events.NewHub(events.DefaultReplaySize)
+	prev := streams
+	t.Cleanup(func() { streams = prev })
+	streams = newClosing()
+	srv := httptest.NewUnstartedServer(ServerRouter(zap.NewNop().Sugar()))
+	srv.Config.RegisterOnShutdown(CloseStreams)
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/orders/stream")
+	require.Equal(t, nil, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/orders/ws", nil)
+	require.Equal(t, nil, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.Equal(t, nil, srv.Config.Shutdown(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// event stream is finished, so EventSource reconnects
+	_, err = io.ReadAll(res.Body)
+	require.Equal(t, nil, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+func TestStreams_Reset(t *testing.T) {
+	events.Stored = events.NewHub(2)
+	srv := httptest.NewServer(ServerRouter(zap.NewNop().Sugar()))
+	defer srv.Close()
+	for _, id := range []string{"lost", "kept", "last"} {
+		events.Stored.Publish(storedOrder(id, "meest"))
+	}
+
+	t.Run("sse", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/orders/stream", nil)
+		require.Equal(t, nil, err)
+		req.Header.Set("Last-Event-ID", "0")
+		res, err := http.DefaultClient.Do(req)
+		require.Equal(t, nil, err)
+		defer res.Body.Close()
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(res.Body)
+		for len(lines) < 9 && scanner.Scan() {
+			if scanner.Text() != "" && !strings.HasPrefix(scanner.Text(), "retry:") {
+				lines = append(lines, scanner.Text())
+			}
+		}
+		require.Equal(t, 9, len(lines))
+		// the stream continues from the oldest kept order
+		assert.Equal(t, []string{"id: 1", "event: reset", `data: {"reset":true,"last_event_id":1}`}, lines[:3])
+		assert.Equal(t, []string{"id: 2", "event: order"}, lines[3:5])
+		assert.Equal(t, []string{"id: 3", "event: order"}, lines[6:8])
+	})
+	t.Run("ws", func(t *testing.T) {
+		// id from before restart of stream
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/orders/ws?last_event_id=7"
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Equal(t, nil, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var rs reset
+		require.Equal(t, nil, conn.ReadJSON(&rs))
+		assert.Equal(t, reset{Reset: true, LastEventID: 3}, rs)
+		events.Stored.Publish(storedOrder("live", "meest"))
+		var s events.Summary
+		require.Equal(t, nil, conn.ReadJSON(&s))
+		assert.Equal(t, "live", s.OrderID)
+	})
+}
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readiness(t *testing.T, c *Checker) (int, Report) {
+	rec := httptest.NewRecorder()
+	c.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	require.Equal(t, nil, json.NewDecoder(rec.Body).Decode(&report))
+	return rec.Code, report
+}
+
+func TestChecker(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	slow := func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	}
+	tests := []struct {
+		name       string
+		checks     map[string]CheckFunc
+		order      []string
+		stopping   bool
+		wantStatus int
+		wantFailed []string
+	}{
+		{
+			name:       "ready",
+			checks:     map[string]CheckFunc{"postgres": ok, "nats": slow},
+			order:      []string{"postgres", "nats"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "dependency_failed",
+			checks:     map[string]CheckFunc{"postgres": ok, "schema": func(ctx context.Context) error { return errors.New("outdated") }},
+			order:      []string{"postgres", "schema"},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"schema"},
+		},
+		{
+			name:       "timed_out",
+			checks:     map[string]CheckFunc{"nats": hang},
+			order:      []string{"nats"},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"nats"},
+		},
+		{
+			name:       "shutting_down",
+			checks:     map[string]CheckFunc{"postgres": ok},
+			order:      []string{"postgres"},
+			stopping:   true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{ShutdownCheck},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(50 * time.Millisecond)
+			for _, name := range tt.order {
+				c.Add(name, tt.checks[name])
+			}
+			if tt.stopping {
+				c.Shutdown()
+			}
+			start := time.Now()
+			code, report := readiness(t, c)
+			assert.Less(t, time.Since(start), 500*time.Millisecond)
+			assert.Equal(t, tt.wantStatus, code)
+			failed := make([]string, 0)
+			for i, r := range report.Checks {
+				if i < len(tt.order) {
+					assert.Equal(t, tt.order[i], r.Name)
+				}
+				if r.Status != StatusOK {
+					failed = append(failed, r.Name)
+					assert.NotEqual(t, "", r.Error)
+				}
+			}
+			if tt.wantFailed == nil {
+				tt.wantFailed = []string{}
+			}
+			assert.Equal(t, tt.wantFailed, failed)
+			if tt.name == "ready" {
+				assert.GreaterOrEqual(t, report.Checks[1].LatencyMs, float64(20))
+			}
+		})
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Add("postgres", func(ctx context.Context) error { return errors.New("down") })
+	c.Shutdown()
+	rec := httptest.NewRecorder()
+	c.Liveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status": "ok", "checks": []}`, rec.Body.String())
+}
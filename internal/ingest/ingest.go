@@ -0,0 +1,184 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// Subject is where publisher sends orders
+	Subject = "foo"
+	// StreamName keeps orders until server acknowledges them, it is created when missing
+	StreamName = "ORDERS"
+	// Durable is name of consumer shared by replicas of server
+	Durable   = "l0-server"
+	BatchSize = 16
+	FetchWait = time.Second
+	// MinPause is delay of redelivery when Psql db failed below threshold of breaker
+	MinPause = time.Second
+	// AckWait is how long message may be stored before JetStream delivers it again
+	AckWait = time.Minute
+)
+
+// Consumer pulls orders from JetStream, so message is acknowledged only after it is handled
+// and nothing is fetched while Psql db is unavailable
+type Consumer struct {
+	JS  nats.JetStreamContext
+	Log *zap.SugaredLogger
+	// Handle returns error only when message has to be delivered again
+	Handle func(m *nats.Msg) error
+	// Pause tells how long to wait before next fetch, nil never pauses
+	Pause func() time.Duration
+
+	sub     *nats.Subscription
+	stopped chan struct{}
+}
+
+func NewConsumer(conn *nats.Conn, log *zap.SugaredLogger) (*Consumer, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with JetStream context: %w", err)
+	}
+	return &Consumer{JS: js, Log: log, stopped: make(chan struct{})}, nil
+}
+
+// Subscribe creates stream of orders and durable pull consumer of it
+func (c *Consumer) Subscribe() error {
+	_, err := c.JS.StreamInfo(StreamName)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = c.JS.AddStream(&nats.StreamConfig{
+			Name:      StreamName,
+			Subjects:  []string{Subject},
+			Retention: nats.WorkQueuePolicy,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("Problem with stream '%s': %w", StreamName, err)
+	}
+	c.sub, err = c.JS.PullSubscribe(Subject, Durable,
+		nats.BindStream(StreamName), nats.AckExplicit(), nats.AckWait(AckWait))
+	if err != nil {
+		return fmt.Errorf("Problem with consumer '%s': %w", Durable, err)
+	}
+	return nil
+}
+
+func (c *Consumer) pause() time.Duration {
+	if c.Pause == nil {
+		return 0
+	}
+	return c.Pause()
+}
+
+// Run fetches messages until done is closed, batch which is already fetched is finished
+func (c *Consumer) Run(done chan struct{}) {
+	defer close(c.stopped)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	for {
+		if !c.wait(ctx) {
+			break
+		}
+		msgs, err := c.fetch(ctx)
+		if ctx.Err() != nil {
+			c.nak(msgs, 0)
+			break
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			c.Log.Warnw("Problem with fetching of orders", "error", err)
+			c.sleep(ctx, MinPause)
+			continue
+		}
+		c.handle(msgs)
+	}
+	c.Log.Infof("Nats consumption is stopped")
+}
+
+// fetch waits FetchWait for messages, it is interrupted when consumer is stopped
+func (c *Consumer) fetch(ctx context.Context) ([]*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, FetchWait)
+	defer cancel()
+	return c.sub.Fetch(BatchSize, nats.Context(ctx))
+}
+
+// wait holds consumption while Pause is positive, false means consumer is stopped
+func (c *Consumer) wait(ctx context.Context) bool {
+	d := c.pause()
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	c.Log.Warnw("Psql db is unavailable, nats consumption is paused", "retry_after", d.String())
+	metrics.NatsPaused.Set(1)
+	defer metrics.NatsPaused.Set(0)
+	for d > 0 {
+		if !c.sleep(ctx, d) {
+			return false
+		}
+		d = c.pause()
+	}
+	return true
+}
+
+func (c *Consumer) sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// handle acknowledges handled messages, after first failure the rest of batch
+// is delivered again once Psql db may be available
+func (c *Consumer) handle(msgs []*nats.Msg) {
+	for i, m := range msgs {
+		err := m.InProgress()
+		if err != nil {
+			c.Log.Warnw("Problem with extending of ack wait", "error", err)
+		}
+		err = c.Handle(m)
+		if err != nil {
+			delay := c.pause()
+			if delay < MinPause {
+				delay = MinPause
+			}
+			c.nak(msgs[i:], delay)
+			return
+		}
+		err = m.Ack()
+		if err != nil {
+			c.Log.Warnw("Problem with ack of order, it is delivered again", "error", err)
+		}
+	}
+}
+
+func (c *Consumer) nak(msgs []*nats.Msg, delay time.Duration) {
+	for _, m := range msgs {
+		err := m.NakWithDelay(delay)
+		if err != nil {
+			c.Log.Warnw("Problem with nak of order, it is delivered after ack wait", "error", err)
+		}
+	}
+}
+
+// Wait returns when Run is stopped, so no message is handled afterwards
+func (c *Consumer) Wait(ctx context.Context) error {
+	select {
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Nats consumption is not stopped: %w", ctx.Err())
+	}
+}
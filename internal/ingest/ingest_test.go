@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/pkg/natstest"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// handled records data of messages, Handle fails while fail is set
+type handled struct {
+	mu   sync.Mutex
+	data []string
+	fail atomic.Bool
+}
+
+func (h *handled) Handle(m *nats.Msg) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.data = append(h.data, string(m.Data))
+	if h.fail.Load() {
+		return errors.New("Psql db is unavailable")
+	}
+	return nil
+}
+
+func (h *handled) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.data)
+}
+
+func newConsumer(t *testing.T, h *handled) (*Consumer, *nats.Conn) {
+	nc := natstest.Connect(t, natstest.Run(t))
+	c, err := NewConsumer(nc, zap.NewNop().Sugar())
+	require.Equal(t, nil, err)
+	c.Handle = h.Handle
+	require.Equal(t, nil, c.Subscribe())
+	return c, nc
+}
+
+func run(t *testing.T, c *Consumer) {
+	done := make(chan struct{})
+	go c.Run(done)
+	t.Cleanup(func() {
+		close(done)
+		require.Equal(t, nil, c.Wait(context.Background()))
+	})
+}
+
+func pending(t *testing.T, c *Consumer) uint64 {
+	info, err := c.JS.StreamInfo(StreamName)
+	require.Equal(t, nil, err)
+	return info.State.Msgs
+}
+
+func TestConsumer_Ack(t *testing.T) {
+	h := &handled{}
+	c, nc := newConsumer(t, h)
+	run(t, c)
+	// publisher sends core nats messages, stream keeps them
+	for _, d := range []string{"a", "b", "c"} {
+		require.Equal(t, nil, nc.Publish(Subject, []byte(d)))
+	}
+	require.Eventually(t, func() bool {
+		return h.Len() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, h.data)
+	require.Eventually(t, func() bool {
+		return pending(t, c) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumer_Pause(t *testing.T) {
+	h := &handled{}
+	c, nc := newConsumer(t, h)
+	var paused atomic.Bool
+	paused.Store(true)
+	c.Pause = func() time.Duration {
+		if paused.Load() {
+			return 50 * time.Millisecond
+		}
+		return 0
+	}
+	run(t, c)
+	require.Equal(t, nil, nc.Publish(Subject, []byte("a")))
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 0, h.Len())
+	assert.Equal(t, uint64(1), pending(t, c))
+
+	paused.Store(false)
+	require.Eventually(t, func() bool {
+		return h.Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumer_Redelivery(t *testing.T) {
+	h := &handled{}
+	h.fail.Store(true)
+	c, nc := newConsumer(t, h)
+	run(t, c)
+	require.Equal(t, nil, nc.Publish(Subject, []byte("a")))
+	require.Eventually(t, func() bool {
+		return h.Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	h.fail.Store(false)
+	// message is delivered again after MinPause
+	require.Eventually(t, func() bool {
+		return h.Len() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "a"}, h.data)
+	require.Eventually(t, func() bool {
+		return pending(t, c) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumer_Stop(t *testing.T) {
+	h := &handled{}
+	h.fail.Store(true)
+	c, nc := newConsumer(t, h)
+	done := make(chan struct{})
+	go c.Run(done)
+	require.Equal(t, nil, nc.Publish(Subject, []byte("a")))
+	require.Eventually(t, func() bool {
+		return h.Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	close(done)
+	require.Equal(t, nil, c.Wait(context.Background()))
+	// message is not dropped, next server gets it
+	assert.Equal(t, uint64(1), pending(t, c))
+	n := h.Len()
+	time.Sleep(2 * MinPause)
+	assert.Equal(t, n, h.Len())
+}
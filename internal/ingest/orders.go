@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// Storage is part of SqlWorker which stores orders of messages
+type Storage interface {
+	AddData(ctx context.Context, data []byte) error
+	AddRejectedEvent(ctx context.Context, data []byte, reason error) error
+}
+
+// Orders is Handle of Consumer. Message is acknowledged when its order is stored,
+// was stored by earlier delivery or is invalid, every other failure is delivered again
+type Orders struct {
+	Storage Storage
+	// Timeout bounds storing of one message
+	Timeout time.Duration
+	Log     *zap.SugaredLogger
+}
+
+func (o *Orders) Handle(m *nats.Msg) error {
+	start := time.Now()
+	ctx, span := tracing.StartReceive(m)
+	defer span.End()
+	mlog := o.Log.With("subject", m.Subject, "trace_id", span.SpanContext().TraceID().String())
+	mlog.Debugw("Message is received", "size", len(m.Data))
+	metrics.NatsReceived.WithLabelValues(m.Subject).Inc()
+	defer func() {
+		metrics.NatsIngestionDuration.WithLabelValues(m.Subject).Observe(time.Since(start).Seconds())
+	}()
+	msgCtx, cancel := o.messageContext(ctx)
+	err := o.Storage.AddData(msgCtx, m.Data)
+	expired := msgCtx.Err()
+	cancel()
+	switch {
+	case err == nil:
+		metrics.NatsStored.WithLabelValues(m.Subject).Inc()
+		return nil
+	case errors.Is(err, postgres.ErrAlreadyStored):
+		mlog.Infow("Order is already stored, message is acknowledged", "error", err)
+		return nil
+	case expired != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		tracing.Fail(span, err)
+		mlog.Warnw("Order is not stored in time, it is delivered again", "timeout", o.Timeout.String(), "error", err)
+		return fmt.Errorf("Order is not stored in time: %w", err)
+	case errors.Is(err, postgres.ErrInvalidOrder):
+		metrics.NatsFailed.WithLabelValues(m.Subject).Inc()
+		tracing.Fail(span, err)
+		mlog.Warnw("Order is rejected", "error", err)
+		rejectErr := o.Storage.AddRejectedEvent(ctx, m.Data, err)
+		if rejectErr != nil {
+			// rejection is saved on next delivery
+			mlog.Errorw("Problem with saving of rejected event", "error", rejectErr)
+			return rejectErr
+		}
+		return nil
+	default:
+		tracing.Fail(span, err)
+		mlog.Warnw("Problem with adding of order, it is delivered again", "error", err)
+		return err
+	}
+}
+
+func (o *Orders) messageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.Timeout)
+}
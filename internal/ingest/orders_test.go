@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/lib/pq"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// storage fails AddData with err, wait makes it block until deadline of message
+type storage struct {
+	err      error
+	wait     bool
+	rejected []error
+}
+
+func (s *storage) AddData(ctx context.Context, data []byte) error {
+	if s.wait {
+		<-ctx.Done()
+		return fmt.Errorf("Problem with execution of Add Order query: %w", &pq.Error{Code: "57014"})
+	}
+	return s.err
+}
+
+func (s *storage) AddRejectedEvent(ctx context.Context, data []byte, reason error) error {
+	s.rejected = append(s.rejected, reason)
+	return nil
+}
+
+func TestOrders_Handle(t *testing.T) {
+	tests := []struct {
+		name      string
+		storage   *storage
+		redeliver bool
+		rejected  int
+	}{
+		{
+			name:    "stored",
+			storage: &storage{},
+		},
+		{
+			// redelivery after failed ack or ack wait
+			name:    "already_stored",
+			storage: &storage{err: fmt.Errorf("%w: %w", postgres.ErrAlreadyStored, &pq.Error{Code: "23505"})},
+		},
+		{
+			name:     "invalid_json",
+			storage:  &storage{err: fmt.Errorf("%w: unexpected end of JSON input", postgres.ErrInvalidOrder)},
+			rejected: 1,
+		},
+		{
+			name:     "constraint",
+			storage:  &storage{err: fmt.Errorf("%w: %w", postgres.ErrInvalidOrder, &pq.Error{Code: "22001"})},
+			rejected: 1,
+		},
+		{
+			name:      "message_timeout",
+			storage:   &storage{wait: true},
+			redeliver: true,
+		},
+		{
+			name:      "query_timeout",
+			storage:   &storage{err: fmt.Errorf("%w: %w", postgres.ErrUnavailable, context.DeadlineExceeded)},
+			redeliver: true,
+		},
+		{
+			name:      "unavailable",
+			storage:   &storage{err: fmt.Errorf("%w: connection refused", postgres.ErrUnavailable)},
+			redeliver: true,
+		},
+		{
+			name:      "unknown",
+			storage:   &storage{err: errors.New("Problem with reading query 'add_address.sql'")},
+			redeliver: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &Orders{Storage: tt.storage, Timeout: 50 * time.Millisecond, Log: zap.NewNop().Sugar()}
+			err := o.Handle(&nats.Msg{Subject: Subject, Data: []byte(`{"order_uid":"a"}`)})
+			if tt.redeliver {
+				require.NotEqual(t, nil, err)
+			} else {
+				require.Equal(t, nil, err)
+			}
+			assert.Equal(t, tt.rejected, len(tt.storage.rejected))
+		})
+	}
+}
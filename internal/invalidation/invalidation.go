@@ -0,0 +1,105 @@
+package invalidation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// Message tells replicas that cached copy of order is stale
+type Message struct {
+	OrderID string `json:"order_uid"`
+	// Origin is replica which changed the order
+	Origin string `json:"origin"`
+}
+
+type Stats struct {
+	Published     uint64 `json:"published"`
+	PublishFailed uint64 `json:"publish_failed"`
+	Received      uint64 `json:"received"`
+}
+
+// Invalidator publishes changes of orders made by this replica
+// and evicts orders changed by every replica including this one
+type Invalidator struct {
+	Conn    *nats.Conn
+	Subject string
+	Origin  string
+	Log     *zap.SugaredLogger
+	// Evict drops order from cache of replica
+	Evict func(orderID string)
+
+	sub           *nats.Subscription
+	published     atomic.Uint64
+	publishFailed atomic.Uint64
+	received      atomic.Uint64
+}
+
+func NewInvalidator(conn *nats.Conn, subject string, log *zap.SugaredLogger) *Invalidator {
+	host, _ := os.Hostname()
+	return &Invalidator{
+		Conn:    conn,
+		Subject: subject,
+		Origin:  fmt.Sprintf("%s-%d", host, os.Getpid()),
+		Log:     log,
+		Evict:   cache.Invalidate,
+	}
+}
+
+// Subscribe starts eviction of orders from messages on Subject
+func (inv *Invalidator) Subscribe() error {
+	sub, err := inv.Conn.Subscribe(inv.Subject, inv.handle)
+	if err != nil {
+		return fmt.Errorf("Problem with subscription to '%s': %w", inv.Subject, err)
+	}
+	inv.sub = sub
+	return nil
+}
+
+func (inv *Invalidator) Unsubscribe() error {
+	if inv.sub == nil {
+		return nil
+	}
+	return inv.sub.Unsubscribe()
+}
+
+func (inv *Invalidator) handle(m *nats.Msg) {
+	var msg Message
+	err := json.Unmarshal(m.Data, &msg)
+	if err != nil || msg.OrderID == "" {
+		inv.Log.Warnw("Problem with decoding of invalidation message", "subject", m.Subject, "data", string(m.Data))
+		return
+	}
+	inv.received.Add(1)
+	inv.Log.Debugw("Order is invalidated", "order_id", msg.OrderID, "subject", m.Subject, "origin", msg.Origin)
+	inv.Evict(msg.OrderID)
+}
+
+// Publish is set as SqlWorker.OnChange. Order is evicted locally at once,
+// other replicas evict it when message is delivered
+func (inv *Invalidator) Publish(orderID string) {
+	inv.Evict(orderID)
+	data, err := json.Marshal(Message{OrderID: orderID, Origin: inv.Origin})
+	if err == nil {
+		err = inv.Conn.Publish(inv.Subject, data)
+	}
+	if err != nil {
+		inv.publishFailed.Add(1)
+		inv.Log.Warnw("Problem with publishing of invalidation", "order_id", orderID, "subject", inv.Subject, "error", err)
+		return
+	}
+	inv.published.Add(1)
+}
+
+func (inv *Invalidator) Stats() Stats {
+	return Stats{
+		Published:     inv.published.Load(),
+		PublishFailed: inv.publishFailed.Load(),
+		Received:      inv.received.Load(),
+	}
+}
@@ -0,0 +1,137 @@
+package invalidation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/pkg/natstest"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const subject = "orders.invalidate"
+
+// replica is wired as cmd/server does: invalidator evicts with cache.Invalidate
+// and is OnChange of SqlWorker
+type replica struct {
+	inv    *Invalidator
+	worker *postgres.SqlWorker
+}
+
+func newReplica(t *testing.T, ns *server.Server, origin string) *replica {
+	nc := natstest.Connect(t, ns)
+	inv := NewInvalidator(nc, subject, zap.NewNop().Sugar())
+	inv.Origin = origin
+	require.Equal(t, nil, inv.Subscribe())
+	require.Equal(t, nil, nc.Flush())
+	return &replica{inv: inv, worker: &postgres.SqlWorker{DB: postgres.DBWorker.DB, OnChange: inv.Publish}}
+}
+
+// setupCache replaces caches of this process, both replicas share them,
+// so other replica keeps its copies in remote cache and evicts only from it
+func setupCache(t *testing.T) {
+	lru, notFound, known := cache.LRUCache, cache.NotFound, cache.Known
+	t.Cleanup(func() {
+		cache.LRUCache, cache.NotFound, cache.Known = lru, notFound, known
+	})
+	cache.LRUCache = cache.NewExpirableLRU(10, time.Minute)
+	cache.NotFound = cache.NewExpirableLRU(10, time.Minute)
+	cache.Known = cache.NewBloom(1000, 0.001)
+	cache.Known.SetReady()
+}
+
+func cached(c cache.Cache, id string) bool {
+	_, ok := c.Get(id)
+	return ok
+}
+
+func TestInvalidator(t *testing.T) {
+	ns := natstest.Run(t)
+	setupCache(t)
+	local := newReplica(t, ns, "local")
+	remote := newReplica(t, ns, "remote")
+	remoteCache := cache.NewExpirableLRU(10, time.Minute)
+	remote.inv.Evict = func(orderID string) {
+		remoteCache.Delete(orderID)
+	}
+	for _, id := range []string{"a", "b"} {
+		cache.LRUCache.Set(id, cache.Entry{Data: []byte("old")})
+		cache.Known.Add(id)
+		remoteCache.Set(id, cache.Entry{Data: []byte("old")})
+	}
+	cache.NotFound.Set("new", cache.Entry{})
+	require.True(t, cache.Known.Rejects("late"))
+
+	// remote replica commits order, SqlWorker.changed calls OnChange
+	remote.worker.OnChange("a")
+	assert.False(t, cached(remoteCache, "a"))
+	require.Eventually(t, func() bool {
+		return !cached(cache.LRUCache, "a")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, cached(cache.LRUCache, "b"))
+
+	// order which was not found or unknown locally is stored by remote replica
+	remote.worker.OnChange("new")
+	remote.worker.OnChange("late")
+	require.Eventually(t, func() bool {
+		return local.inv.Stats().Received == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, cached(cache.NotFound, "new"))
+	assert.False(t, cache.Known.Rejects("new"))
+	assert.False(t, cache.Known.Rejects("late"))
+
+	// local commit evicts at once, remote replica evicts on delivery
+	local.worker.OnChange("b")
+	assert.False(t, cached(cache.LRUCache, "b"))
+	require.Eventually(t, func() bool {
+		return !cached(remoteCache, "b")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), local.inv.Stats().Published)
+	assert.Equal(t, uint64(3), remote.inv.Stats().Published)
+
+	// malformed message is ignored
+	require.Equal(t, nil, remote.inv.Conn.Publish(subject, []byte("b")))
+	remote.worker.OnChange("c")
+	require.Eventually(t, func() bool {
+		return local.inv.Stats().Received == 5
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestInvalidator_AddData goes through commits of order and of its deletion in Psql db
+func TestInvalidator_AddData(t *testing.T) {
+	ctx := context.Background()
+	postgres.Start(ctx, t)
+	ns := natstest.Run(t)
+	setupCache(t)
+	newReplica(t, ns, "local")
+	remote := newReplica(t, ns, "remote")
+	remote.inv.Evict = func(string) {}
+
+	data, err := postgres.Read("/statics/test/order.json")
+	require.Equal(t, nil, err)
+	var ord order.Order
+	require.Equal(t, nil, json.Unmarshal([]byte(data), &ord))
+	cache.LRUCache.Set(ord.OrderID, cache.Entry{Data: []byte("old")})
+	cache.NotFound.Set(ord.OrderID, cache.Entry{})
+
+	err = remote.worker.AddData(ctx, []byte(data))
+	require.Equal(t, nil, err)
+	require.Eventually(t, func() bool {
+		return !cached(cache.LRUCache, ord.OrderID) && !cached(cache.NotFound, ord.OrderID)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, cache.Known.Rejects(ord.OrderID))
+
+	cache.LRUCache.Set(ord.OrderID, cache.Entry{Data: []byte(data)})
+	err = remote.worker.DeleteDataByOrderID(ctx, []byte(data))
+	require.Equal(t, nil, err)
+	require.Eventually(t, func() bool {
+		return !cached(cache.LRUCache, ord.OrderID)
+	}, 5*time.Second, 10*time.Millisecond)
+}
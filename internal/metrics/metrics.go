@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akashipov/L0project/internal/breaker"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "l0"
+
+// UnmatchedRoute labels requests which don't match any route,
+// so unknown paths don't create new series
+const UnmatchedRoute = "unmatched"
+
+// Registry holds every metric of the server, other packages register their collectors in it
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of http requests by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	NatsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "nats",
+		Name:      "messages_received_total",
+		Help:      "Messages received from nats.",
+	}, []string{"subject"})
+	NatsStored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "nats",
+		Name:      "messages_stored_total",
+		Help:      "Messages from nats stored to Psql db as orders.",
+	}, []string{"subject"})
+	NatsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "nats",
+		Name:      "messages_failed_total",
+		Help:      "Messages from nats which were rejected.",
+	}, []string{"subject"})
+	NatsIngestionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "nats",
+		Name:      "ingestion_duration_seconds",
+		Help:      "Time from receiving of nats message to end of its processing.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subject"})
+
+	PostgresDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of SqlWorker methods with their transactions.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	PostgresBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "postgres",
+		Name:      "breaker_state",
+		Help:      "Circuit breaker of Psql db, 1 for its current state.",
+	}, []string{"state"})
+	NatsPaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "nats",
+		Name:      "paused",
+		Help:      "1 while consumption of nats is paused because Psql db is unavailable.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		NatsReceived,
+		NatsStored,
+		NatsFailed,
+		NatsIngestionDuration,
+		PostgresDuration,
+		PostgresBreakerState,
+		NatsPaused,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObservePostgres is deferred by SqlWorker methods with their start time
+func ObservePostgres(method string, start time.Time) {
+	PostgresDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// SetBreakerState marks state of Psql db circuit breaker
+func SetBreakerState(state string) {
+	for _, st := range []string{breaker.StateClosed, breaker.StateOpen, breaker.StateHalfOpen} {
+		v := 0.0
+		if st == state {
+			v = 1
+		}
+		PostgresBreakerState.WithLabelValues(st).Set(v)
+	}
+}
+
+// Handle measures requests served by chi router next. Route context is made
+// here, so route pattern is still known after the router returns
+func Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rctx := chi.RouteContext(r.Context())
+		if rctx == nil {
+			rctx = chi.NewRouteContext()
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := rctx.RoutePattern()
+		if route == "" {
+			route = UnmatchedRoute
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		HTTPRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	HTTPRequestDuration.Reset()
+	r := chi.NewRouter()
+	r.Get("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("order"))
+	})
+	sub := chi.NewRouter()
+	sub.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Mount("/webhooks", sub)
+	h := Handle(r)
+	for _, path := range []string{"/order/1", "/order/2", "/order/missing", "/webhooks/7", "/unknown"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`l0_http_request_duration_seconds_count{method="GET",route="/order/{id}",status="200"} 2`,
+		`l0_http_request_duration_seconds_count{method="GET",route="/order/{id}",status="404"} 1`,
+		`l0_http_request_duration_seconds_count{method="GET",route="/webhooks/{id}",status="200"} 1`,
+		`l0_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		assert.True(t, strings.Contains(body, want), want)
+	}
+	assert.Equal(t, 4, testutil.CollectAndCount(HTTPRequestDuration))
+}
+
+func TestHandler(t *testing.T) {
+	NatsReceived.WithLabelValues("foo").Inc()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, `l0_nats_messages_received_total{subject="foo"}`))
+	assert.True(t, strings.Contains(body, "go_goroutines"))
+}
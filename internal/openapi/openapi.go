@@ -0,0 +1,419 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/health"
+	"github.com/akashipov/L0project/internal/storage/order"
+)
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// Operations returns all operations of path item by http method
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := make(map[string]*Operation)
+	for method, op := range map[string]*Operation{
+		http.MethodGet:    p.Get,
+		http.MethodPost:   p.Post,
+		http.MethodPut:    p.Put,
+		http.MethodDelete: p.Delete,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// PathFromRoute converts chi route pattern to OpenAPI path,
+// wildcard suffix '*' is described as '{path}' parameter
+func PathFromRoute(pattern string) string {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.TrimSuffix(pattern, "*") + "{path}"
+	}
+	return pattern
+}
+
+func text(description string) *Response {
+	return &Response{
+		Description: description,
+		Content: map[string]*MediaType{
+			"text/plain": {Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+func html(description string) *Response {
+	return &Response{
+		Description: description,
+		Content: map[string]*MediaType{
+			"text/html": {Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+func redirect(description string) *Response {
+	return &Response{
+		Description: description,
+		Headers: map[string]*Header{
+			"Location": {Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+func pathParam(name, description string) Parameter {
+	return Parameter{
+		Name:        name,
+		In:          "path",
+		Description: description,
+		Required:    true,
+		Schema:      &Schema{Type: "string"},
+	}
+}
+
+func streamParams() []Parameter {
+	params := make([]Parameter, 0, 4)
+	for _, name := range []string{"delivery_service", "customer_id", "entry"} {
+		params = append(params, Parameter{
+			Name:        name,
+			In:          "query",
+			Description: "Send only orders with this " + name,
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	return append(params, Parameter{
+		Name:        "last_event_id",
+		In:          "query",
+		Description: "Resume after this event, only kept events are replayed",
+		Schema:      &Schema{Type: "integer", Format: "int64"},
+	})
+}
+
+// Spec builds description of every route of handlers.ServerRouter
+func Spec() *Document {
+	reg := NewSchemaRegistry()
+	orderRef := reg.Ref(order.Order{})
+	summaryRef := reg.Ref(events.Summary{})
+	healthRef := reg.Ref(health.Report{})
+	orderID := pathParam("id", "Order uid")
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       "L0 orders API",
+			Description: "Orders received from NATS and stored in Postgres",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]*PathItem{
+			"/order/{id}": {
+				Get: &Operation{
+					OperationID: "getOrder",
+					Summary:     "Get order by its uid",
+					Tags:        []string{"orders"},
+					Parameters:  []Parameter{orderID},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "Order",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: orderRef},
+							},
+							Headers: map[string]*Header{
+								"X-Cache": {Description: "hit, stale or miss of cache, stale copies are served while Psql db is unavailable", Schema: &Schema{Type: "string"}},
+							},
+						},
+						"404": text("Order was not found"),
+						"500": text("Order could not be loaded"),
+						"503": {
+							Description: "Order is not cached and Psql db is unavailable",
+							Content: map[string]*MediaType{
+								"text/plain": {Schema: &Schema{Type: "string"}},
+							},
+							Headers: map[string]*Header{
+								"Retry-After": {Description: "Seconds until Psql db is tried again", Schema: &Schema{Type: "integer"}},
+							},
+						},
+						"504": text("Deadline of request was exceeded"),
+					},
+				},
+			},
+			"/orders/stream": {
+				Get: &Operation{
+					OperationID: "streamOrders",
+					Summary:     "Server-Sent Events with orders stored from NATS",
+					Tags:        []string{"orders"},
+					Parameters: append(streamParams(), Parameter{
+						Name:        "Last-Event-ID",
+						In:          "header",
+						Description: "Resume after this event, only kept events are replayed",
+						Schema:      &Schema{Type: "integer", Format: "int64"},
+					}),
+					Responses: map[string]*Response{
+						"200": {
+							Description: "Stream of 'order' events, data is a json of EventsSummary",
+							Content: map[string]*MediaType{
+								"text/event-stream": {Schema: summaryRef},
+							},
+						},
+						"400": text("Bad last event id"),
+					},
+				},
+			},
+			"/orders/ws": {
+				Get: &Operation{
+					OperationID: "wsOrders",
+					Summary:     "WebSocket with orders stored from NATS",
+					Tags:        []string{"orders"},
+					Parameters:  streamParams(),
+					Responses: map[string]*Response{
+						"101": {Description: "Switching to WebSocket, each text message is a json of EventsSummary"},
+						"400": text("Bad last event id or handshake"),
+					},
+				},
+			},
+			"/ui/": {
+				Get: &Operation{
+					OperationID: "uiIndex",
+					Summary:     "Search form and recent lookups",
+					Tags:        []string{"ui"},
+					Responses: map[string]*Response{
+						"200": html("Index page"),
+					},
+				},
+			},
+			"/ui/search": {
+				Get: &Operation{
+					OperationID: "uiSearch",
+					Summary:     "Search order by id, track number or transaction",
+					Tags:        []string{"ui"},
+					Parameters: []Parameter{
+						{
+							Name:        "q",
+							In:          "query",
+							Description: "Searched value",
+							Required:    true,
+							Schema:      &Schema{Type: "string"},
+						},
+						{
+							Name:        "by",
+							In:          "query",
+							Description: "Field to search by",
+							Schema:      &Schema{Type: "string", Enum: []string{"id", "track", "transaction"}},
+						},
+					},
+					Responses: map[string]*Response{
+						"303": redirect("Redirect to the found order page"),
+						"400": html("Empty query or unknown search type"),
+						"404": html("Order was not found"),
+						"500": html("Search failed"),
+					},
+				},
+			},
+			"/ui/order/{id}": {
+				Get: &Operation{
+					OperationID: "uiOrder",
+					Summary:     "Order page with delivery, payment and items",
+					Tags:        []string{"ui"},
+					Parameters:  []Parameter{orderID},
+					Responses: map[string]*Response{
+						"200": html("Order page"),
+						"404": html("Order was not found"),
+						"500": html("Order could not be loaded"),
+					},
+				},
+			},
+			"/ui/static/{path}": {
+				Get: &Operation{
+					OperationID: "uiStatic",
+					Summary:     "Static assets of web interface",
+					Tags:        []string{"ui"},
+					Parameters:  []Parameter{pathParam("path", "Asset path")},
+					Responses: map[string]*Response{
+						"200": {Description: "Asset content"},
+						"404": text("Asset was not found"),
+					},
+				},
+			},
+			"/debug/vars": {
+				Get: &Operation{
+					OperationID: "getDebugVars",
+					Summary:     "Runtime variables, e.g. 'nats_outbox_relay' lag of 'orders.stored.<order_uid>' events",
+					Tags:        []string{"debug"},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "Variables published with expvar",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: &Schema{Type: "object"}},
+							},
+						},
+					},
+				},
+			},
+			"/healthz": {
+				Get: &Operation{
+					OperationID: "getLiveness",
+					Summary:     "Liveness, server is able to answer http requests",
+					Tags:        []string{"health"},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "Server is alive",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: healthRef},
+							},
+						},
+					},
+				},
+			},
+			"/readyz": {
+				Get: &Operation{
+					OperationID: "getReadiness",
+					Summary:     "Readiness: Psql db ping, nats connection, cache warmup and schema version",
+					Tags:        []string{"health"},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "Every check passed, latency of each check is reported",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: healthRef},
+							},
+						},
+						"503": {
+							Description: "Some check failed or server is shutting down",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: healthRef},
+							},
+						},
+					},
+				},
+			},
+			"/metrics": {
+				Get: &Operation{
+					OperationID: "getMetrics",
+					Summary:     "Prometheus metrics of http, cache, nats and Psql db",
+					Tags:        []string{"debug"},
+					Responses: map[string]*Response{
+						"200": text("Metrics in Prometheus text format"),
+					},
+				},
+			},
+			"/openapi.json": {
+				Get: &Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "This document",
+					Tags:        []string{"docs"},
+					Responses: map[string]*Response{
+						"200": {
+							Description: "OpenAPI document",
+							Content: map[string]*MediaType{
+								"application/json": {Schema: &Schema{Type: "object"}},
+							},
+						},
+					},
+				},
+			},
+			"/docs": {
+				Get: &Operation{
+					OperationID: "getDocs",
+					Summary:     "Interactive documentation",
+					Tags:        []string{"docs"},
+					Responses: map[string]*Response{
+						"200": html("Documentation page"),
+					},
+				},
+			},
+		},
+	}
+	doc.Components.Schemas = reg.Schemas
+	return doc
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(Spec(), "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
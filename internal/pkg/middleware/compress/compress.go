@@ -1,51 +1,293 @@
 package compress
 
 import (
+	"bufio"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/zap"
 )
 
-type GzipWriter struct {
-	OldW   http.ResponseWriter
-	Writer *gzip.Writer
-	Log    *zap.SugaredLogger
+// Content encodings supported by middleware
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// DefaultMinSize is a body size which is worth to compress
+const DefaultMinSize = 1024
+
+type Config struct {
+	// Encodings are ordered by preference of server, it breaks ties of q-values
+	Encodings []string
+	// MinSize is a body size from which response is compressed
+	MinSize int
+}
+
+// encoder is a streaming compressor taken from pool and reset to new response
+type encoder interface {
+	io.WriteCloser
+	Flush() error
 }
 
-func (w GzipWriter) WriteHeader(statusCode int) {
-	w.OldW.WriteHeader(statusCode)
+var pools = map[string]*sync.Pool{
+	Gzip: {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	}},
+	Brotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	Zstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
 }
 
-func (w GzipWriter) Header() http.Header {
-	return w.OldW.Header()
+func getEncoder(enc string, w io.Writer) encoder {
+	switch e := pools[enc].Get().(type) {
+	case *gzip.Writer:
+		e.Reset(w)
+		return e
+	case *brotli.Writer:
+		e.Reset(w)
+		return e
+	case *zstd.Encoder:
+		e.Reset(w)
+		return e
+	}
+	return nil
 }
 
-func (w GzipWriter) Write(b []byte) (int, error) {
-	contentType := w.OldW.Header().Get("Content-Type")
-	w.Log.Infof(" Content-Type of response: '%s'\n", contentType)
-	w.Log.Infoln(" Started encoding...")
-	w.OldW.Header().Set("Content-Encoding", "gzip")
-	w.WriteHeader(http.StatusOK)
-	return w.Writer.Write(b)
+// ParseEncodings reads comma separated list like 'br,gzip'
+func ParseEncodings(list string) ([]string, error) {
+	encodings := make([]string, 0)
+	for _, enc := range strings.Split(list, ",") {
+		enc = strings.TrimSpace(enc)
+		if enc == "" {
+			continue
+		}
+		if _, ok := pools[enc]; !ok {
+			return nil, fmt.Errorf("Unknown content encoding '%s'", enc)
+		}
+		encodings = append(encodings, enc)
+	}
+	return encodings, nil
 }
 
-func GzipHandle(next http.Handler, log *zap.SugaredLogger) http.Handler {
+// Handle compresses responses by encoding negotiated with Accept-Encoding.
+// Responses encoded by handler itself, bodies smaller than MinSize
+// and responses without body are sent as is
+func Handle(next http.Handler, cfg Config, log *zap.SugaredLogger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			log.Infof("Skip gzip... content-type = '%s'\n", r.Header.Get("Content-Type"))
+		enc := Negotiate(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+		if enc == "" || r.Method == http.MethodHead {
 			next.ServeHTTP(w, r)
 			return
 		}
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		if err != nil {
-			io.WriteString(w, err.Error())
-			return
+		cw := &Writer{rw: w, encoding: enc, minSize: cfg.MinSize}
+		defer func() {
+			err := cw.Close()
+			if err != nil {
+				log.Warnf("Problem with compression of response: %s", err.Error())
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Writer holds body until it reaches min size, then sends
+// headers with status of handler and compresses the rest
+type Writer struct {
+	rw       http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+	hijacked bool
+}
+
+func (w *Writer) Header() http.Header {
+	return w.rw.Header()
+}
+
+func (w *Writer) WriteHeader(statusCode int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	// informational responses are sent at once, the final status follows them
+	if statusCode >= 100 && statusCode < 200 {
+		w.rw.WriteHeader(statusCode)
+		return
+	}
+	w.status = statusCode
+	if !bodyAllowed(statusCode) {
+		w.passthrough()
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// compressible rejects bodies which are compressed already
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/x-gzip"} {
+		if strings.HasPrefix(ct, prefix) {
+			return false
 		}
-		defer gz.Close()
+	}
+	return true
+}
 
-		next.ServeHTTP(GzipWriter{OldW: w, Writer: gz, Log: log}, r)
-	})
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		if !compressible(w.Header()) || w.knownSmall() {
+			w.passthrough()
+		} else {
+			w.buf = append(w.buf, b...)
+			if len(w.buf) < w.minSize {
+				return len(b), nil
+			}
+			err := w.startCompression()
+			if err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.rw.Write(b)
+}
+
+// knownSmall uses Content-Length set by handler to decide before buffering
+func (w *Writer) knownSmall() bool {
+	n, err := strconv.Atoi(w.Header().Get("Content-Length"))
+	return err == nil && n < w.minSize
+}
+
+// passthrough sends headers and buffered body as is
+func (w *Writer) passthrough() error {
+	w.decided = true
+	if bodyAllowed(w.status) {
+		AddVary(w.Header())
+	}
+	if w.status != 0 {
+		w.rw.WriteHeader(w.status)
+	}
+	if len(w.buf) > 0 {
+		_, err := w.rw.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) startCompression() error {
+	w.decided = true
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	AddVary(h)
+	// length of compressed body is unknown
+	h.Del("Content-Length")
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.rw.WriteHeader(w.status)
+	w.enc = getEncoder(w.encoding, w.rw)
+	if len(w.buf) > 0 {
+		_, err := w.enc.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+	return nil
+}
+
+// Flush sends everything written so far, response which is flushed
+// before min size is reached is compressed, as it is most likely a stream
+func (w *Writer) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if compressible(w.Header()) && bodyAllowed(w.status) {
+			w.startCompression()
+		} else {
+			w.passthrough()
+		}
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack gives connection to handler, e.g. for websocket,
+// nothing is written by Writer after it
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.rw.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer doesn't support hijacking")
+	}
+	if w.decided {
+		return nil, nil, errors.New("Response was started already, connection can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the original writer
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// Close finishes compressed stream or sends small body as is
+func (w *Writer) Close() error {
+	if w.hijacked {
+		return nil
+	}
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// handler wrote nothing, server sends 200 with empty body itself
+			return nil
+		}
+		return w.passthrough()
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	pools[w.encoding].Put(w.enc)
+	w.enc = nil
+	return err
 }
@@ -0,0 +1,196 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "empty", header: "", want: ""},
+		{name: "single", header: "gzip", want: "gzip"},
+		{name: "server_preference", header: "gzip, deflate, br", want: "br"},
+		{name: "q_values", header: "br;q=0.5, gzip;q=0.8", want: "gzip"},
+		{name: "refused", header: "gzip;q=0, identity", want: ""},
+		{name: "wildcard", header: "*;q=0.1, br;q=0", want: "zstd"},
+		{name: "case_and_spaces", header: " GZIP ; q=1.0 ", want: "gzip"},
+		{name: "not_offered", header: "deflate", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Negotiate(tt.header, offered))
+		})
+	}
+}
+
+func decode(t *testing.T, encoding string, body io.Reader) []byte {
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		gz, err := gzip.NewReader(body)
+		require.Equal(t, nil, err)
+		r = gz
+	case Brotli:
+		r = brotli.NewReader(body)
+	case Zstd:
+		zr, err := zstd.NewReader(body)
+		require.Equal(t, nil, err)
+		defer zr.Close()
+		r = zr
+	default:
+		r = body
+	}
+	data, err := io.ReadAll(r)
+	require.Equal(t, nil, err)
+	return data
+}
+
+func TestHandle(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"order_uid": "b563feb7b2b84b6test"}`), 100)
+	small := []byte(`{"order_uid": "b563feb7b2b84b6test"}`)
+	cfg := Config{Encodings: []string{Brotli, Zstd, Gzip}, MinSize: DefaultMinSize}
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		status         int
+		body           []byte
+		setLength      bool
+		preEncoded     bool
+		wantEncoding   string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", status: http.StatusOK, body: large, wantEncoding: Gzip},
+		{name: "brotli", acceptEncoding: "gzip, br", status: http.StatusOK, body: large, wantEncoding: Brotli},
+		{name: "zstd", acceptEncoding: "zstd, gzip;q=0.5", status: http.StatusOK, body: large, wantEncoding: Zstd},
+		{name: "status_preserved", acceptEncoding: "gzip", status: http.StatusNotFound, body: large, wantEncoding: Gzip},
+		{name: "small_body", acceptEncoding: "gzip", status: http.StatusOK, body: small, wantEncoding: ""},
+		{name: "small_body_with_length", acceptEncoding: "gzip", status: http.StatusBadRequest, body: small, setLength: true, wantEncoding: ""},
+		{name: "encoded_by_handler", acceptEncoding: "gzip, br", status: http.StatusOK, body: large, setLength: true, preEncoded: true, wantEncoding: "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.setLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+				if tt.preEncoded {
+					w.Header().Set("Content-Encoding", "br")
+					AddVary(w.Header())
+				}
+				w.WriteHeader(tt.status)
+				// body comes in parts to check buffering up to min size
+				w.Write(tt.body[:len(tt.body)/2])
+				w.Write(tt.body[len(tt.body)/2:])
+			}), cfg, zap.NewNop().Sugar())
+			req := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			res := rec.Result()
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, tt.wantEncoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, []string{"Accept-Encoding"}, res.Header.Values("Vary"))
+			if tt.setLength {
+				assert.Equal(t, strconv.Itoa(len(tt.body)), res.Header.Get("Content-Length"))
+			} else {
+				assert.Equal(t, "", res.Header.Get("Content-Length"))
+			}
+			encoding := tt.wantEncoding
+			if tt.preEncoded {
+				encoding = ""
+			}
+			assert.Equal(t, tt.body, decode(t, encoding, rec.Body))
+		})
+	}
+}
+
+func TestHandle_NoBody(t *testing.T) {
+	cfg := Config{Encodings: []string{Gzip}, MinSize: 0}
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		h := Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}), cfg, zap.NewNop().Sugar())
+		req := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code)
+		assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, 0, rec.Body.Len())
+	}
+}
+
+func TestHandle_Flush(t *testing.T) {
+	cfg := Config{Encodings: []string{Gzip}, MinSize: DefaultMinSize}
+	next := make(chan struct{})
+	srv := httptest.NewServer(Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-next
+		w.Write([]byte("data: second\n\n"))
+	}), cfg, zap.NewNop().Sugar()))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.Equal(t, nil, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	require.Equal(t, nil, err)
+	defer res.Body.Close()
+	assert.Equal(t, Gzip, res.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(res.Body)
+	require.Equal(t, nil, err)
+	// first event is readable before handler writes the second one
+	first := make([]byte, len("data: first\n\n"))
+	_, err = io.ReadFull(gz, first)
+	require.Equal(t, nil, err)
+	assert.Equal(t, "data: first\n\n", string(first))
+	close(next)
+	rest, err := io.ReadAll(gz)
+	require.Equal(t, nil, err)
+	assert.Equal(t, "data: second\n\n", string(rest))
+}
+
+func TestHandle_Hijack(t *testing.T) {
+	cfg := Config{Encodings: []string{Gzip}, MinSize: 0}
+	srv := httptest.NewServer(Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\nraw ok")
+		rw.Flush()
+	}), cfg, zap.NewNop().Sugar()))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.Equal(t, nil, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	require.Equal(t, nil, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	body, err := io.ReadAll(res.Body)
+	require.Equal(t, nil, err)
+	assert.Equal(t, "raw ok", string(body))
+}
@@ -0,0 +1,63 @@
+package compress
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AcceptedEncodings parses Accept-Encoding header into encodings with their q-values,
+// encoding without q-value has q = 1
+func AcceptedEncodings(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil && v >= 0 && v <= 1 {
+				q = v
+			}
+		}
+		accepted[name] = q
+	}
+	return accepted
+}
+
+// Negotiate chooses encoding with the highest q-value among offered ones,
+// offered encodings are ordered by preference of server which breaks ties.
+// Empty string means response has to be sent as is
+func Negotiate(header string, offered []string) string {
+	accepted := AcceptedEncodings(header)
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := accepted[enc]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// AddVary marks response as dependent on Accept-Encoding once
+func AddVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats of access log
+const (
+	FormatJSON     = "json"
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+)
+
+const OutputStdout = "stdout"
+
+// CacheHeader is set by handlers which serve responses from cache
+const CacheHeader = "X-Cache"
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessConfig struct {
+	Format string
+	// Output is stdout or path to file rotated by MaxBytes
+	Output     string
+	MaxBytes   int64
+	MaxBackups int
+	// SampleRate is fraction of responses with status 200 which are logged,
+	// other statuses are logged always
+	SampleRate float64
+}
+
+type AccessLog struct {
+	cfg    AccessConfig
+	out    io.Writer
+	closer io.Closer
+	json   *zap.Logger
+	mu     sync.Mutex
+	sample func() float64
+}
+
+func NewAccessLog(cfg AccessConfig) (*AccessLog, error) {
+	switch cfg.Format {
+	case FormatJSON, FormatCommon, FormatCombined:
+	default:
+		return nil, fmt.Errorf("Unknown access log format '%s'", cfg.Format)
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("Access log sample rate %g is out of [0, 1]", cfg.SampleRate)
+	}
+	if cfg.Output == "" || cfg.Output == OutputStdout {
+		return newAccessLog(cfg, os.Stdout, nil), nil
+	}
+	rf, err := OpenRotatingFile(cfg.Output, cfg.MaxBytes, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return newAccessLog(cfg, rf, rf), nil
+}
+
+func newAccessLog(cfg AccessConfig, out io.Writer, closer io.Closer) *AccessLog {
+	a := &AccessLog{cfg: cfg, out: out, closer: closer, sample: rand.Float64}
+	if cfg.Format == FormatJSON {
+		encCfg := zap.NewProductionEncoderConfig()
+		encCfg.TimeKey = "time"
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		a.json = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), zapcore.AddSync(out), zap.InfoLevel))
+	}
+	return a
+}
+
+func (a *AccessLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// Handle logs every request after it is served
+func (a *AccessLog) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if rw.status == http.StatusOK && a.cfg.SampleRate < 1 && a.sample() >= a.cfg.SampleRate {
+			return
+		}
+		a.write(r, rw, start)
+	})
+}
+
+func (a *AccessLog) write(r *http.Request, rw *responseRecorder, start time.Time) {
+	if a.json != nil {
+		a.json.Info("request",
+			zap.String("request_id", GetRequestID(r.Context())),
+			zap.String("method", r.Method),
+			zap.String("uri", r.RequestURI),
+			zap.String("proto", r.Proto),
+			zap.Int("status", rw.status),
+			zap.Int("size", rw.size),
+			zap.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			zap.String("client_ip", clientIP(r)),
+			zap.String("user_agent", r.UserAgent()),
+			zap.String("referer", r.Referer()),
+			zap.String("cache", rw.Header().Get(CacheHeader)),
+		)
+		return
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if rw.size > 0 {
+		size = strconv.Itoa(rw.size)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		clientIP(r), user, start.Format(clfTimeFormat),
+		r.Method+" "+r.RequestURI+" "+r.Proto, rw.status, size,
+	)
+	if a.cfg.Format == FormatCombined {
+		line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.out, line+"\n")
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// responseRecorder keeps status and size of response,
+// streams and websockets work through it as through original writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rw *responseRecorder) WriteHeader(statusCode int) {
+	if rw.status == 0 && statusCode >= 200 {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += n
+	return n, err
+}
+
+func (rw *responseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer doesn't support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err == nil && rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetRequestID(r.Context())
+	}))
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "propagated", header: "abc-123", keep: true},
+		{name: "assigned", header: "", keep: false},
+		{name: "replaced_bad", header: "bad id\n", keep: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, got, rec.Header().Get(RequestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.header, got)
+			} else {
+				assert.Len(t, got, 32)
+			}
+		})
+	}
+}
+
+func serve(a *AccessLog, status int, target string) {
+	h := RequestID(a.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(CacheHeader, "hit")
+		w.WriteHeader(status)
+		w.Write([]byte("order"))
+	})))
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("Referer", "http://localhost/ui/")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	var out bytes.Buffer
+	a := newAccessLog(AccessConfig{Format: FormatJSON, SampleRate: 1}, &out, nil)
+	serve(a, http.StatusNotFound, "/order/1?x=y")
+
+	var line map[string]any
+	require.Equal(t, nil, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/order/1?x=y", line["uri"])
+	assert.Equal(t, float64(http.StatusNotFound), line["status"])
+	assert.Equal(t, float64(len("order")), line["size"])
+	assert.Equal(t, "10.0.0.1", line["client_ip"])
+	assert.Equal(t, "curl/8.0", line["user_agent"])
+	assert.Equal(t, "hit", line["cache"])
+	assert.Contains(t, line, "duration_ms")
+	assert.Contains(t, line, "time")
+}
+
+func TestAccessLog_CLF(t *testing.T) {
+	tests := []struct {
+		format string
+		suffix string
+	}{
+		{format: FormatCommon, suffix: `"GET /order/1 HTTP/1.1" 200 5`},
+		{format: FormatCombined, suffix: `"GET /order/1 HTTP/1.1" 200 5 "http://localhost/ui/" "curl/8.0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			a := newAccessLog(AccessConfig{Format: tt.format, SampleRate: 1}, &out, nil)
+			serve(a, http.StatusOK, "/order/1")
+			line := strings.TrimSuffix(out.String(), "\n")
+			assert.True(t, strings.HasPrefix(line, "10.0.0.1 - - ["), line)
+			assert.True(t, strings.HasSuffix(line, "] "+tt.suffix), line)
+		})
+	}
+}
+
+func TestAccessLog_Sampling(t *testing.T) {
+	var out bytes.Buffer
+	a := newAccessLog(AccessConfig{Format: FormatCommon, SampleRate: 0.5}, &out, nil)
+	samples := []float64{0.7, 0.2}
+	a.sample = func() float64 {
+		s := samples[0]
+		samples = samples[1:]
+		return s
+	}
+	serve(a, http.StatusOK, "/order/1")
+	serve(a, http.StatusOK, "/order/2")
+	// errors are not sampled
+	serve(a, http.StatusInternalServerError, "/order/3")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "/order/2")
+	assert.Contains(t, lines[1], "/order/3")
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	require.Equal(t, nil, err)
+	defer rf.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = rf.Write([]byte(line))
+		require.Equal(t, nil, err)
+	}
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.Equal(t, nil, err)
+		assert.Equal(t, want, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,69 +1,71 @@
 package logger
 
 import (
-	"net/http"
-	"time"
+	"fmt"
 
+	"github.com/akashipov/L0project/internal/arguments"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-type (
-	responseData struct {
-		status int
-		size   int
-	}
-
-	loggingResponseWriter struct {
-		http.ResponseWriter
-		responseData *responseData
-	}
+// Presets of logger configuration
+const (
+	PresetDevelopment = "development"
+	PresetProduction  = "production"
 )
 
-func (r *loggingResponseWriter) Write(b []byte) (int, error) {
-	size, err := r.ResponseWriter.Write(b)
-	r.responseData.size += size
-	return size, err
-}
+// FormatConsole is human readable format of application log,
+// FormatJSON is shared with access log
+const FormatConsole = "console"
 
-func (r *loggingResponseWriter) WriteHeader(statusCode int) {
-	r.ResponseWriter.WriteHeader(statusCode)
-	r.responseData.status = statusCode
-}
+// Level is shared by every logger made by New, so it can be changed at runtime
+var Level = zap.NewAtomicLevel()
 
-func WithLogging(h http.Handler, s *zap.SugaredLogger) func(w http.ResponseWriter, r *http.Request) {
-	logFn := func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+type Config struct {
+	// Level is empty to keep level of preset
+	Level string
+	// Format is empty to keep format of preset
+	Format string
+	Preset string
+}
 
-		uri := r.RequestURI
-		method := r.Method
-		responseData := &responseData{
-			status: 0,
-			size:   0,
-		}
-		lw := loggingResponseWriter{
-			ResponseWriter: w,
-			responseData:   responseData,
+func New(cfg Config) (*zap.SugaredLogger, error) {
+	var zcfg zap.Config
+	switch cfg.Preset {
+	case PresetDevelopment, "":
+		zcfg = zap.NewDevelopmentConfig()
+	case PresetProduction:
+		zcfg = zap.NewProductionConfig()
+		zcfg.EncoderConfig.TimeKey = "time"
+		zcfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	default:
+		return nil, fmt.Errorf("Unknown log preset '%s'", cfg.Preset)
+	}
+	switch cfg.Format {
+	case FormatConsole, FormatJSON:
+		zcfg.Encoding = cfg.Format
+	case "":
+	default:
+		return nil, fmt.Errorf("Unknown log format '%s'", cfg.Format)
+	}
+	level := zcfg.Level.Level()
+	if cfg.Level != "" {
+		var err error
+		level, err = zapcore.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, fmt.Errorf("Unknown log level '%s'", cfg.Level)
 		}
-		h.ServeHTTP(&lw, r)
-
-		duration := time.Since(start)
-
-		s.Infoln(
-			"uri", uri,
-			"method", method,
-			"status", responseData.status,
-			"duration", duration,
-			"size", responseData.size,
-		)
 	}
-	return logFn
-}
-
-func GetLogger() (*zap.SugaredLogger, error) {
-	logger, err := zap.NewDevelopment()
+	Level.SetLevel(level)
+	zcfg.Level = Level
+	logger, err := zcfg.Build()
 	if err != nil {
 		return nil, err
 	}
-	log := *logger.Sugar()
-	return &log, nil
+	return logger.Sugar(), nil
+}
+
+// GetLogger makes logger configured by arguments
+func GetLogger() (*zap.SugaredLogger, error) {
+	return New(Config{Level: arguments.LogLevel, Format: arguments.LogFormat, Preset: arguments.LogPreset})
 }
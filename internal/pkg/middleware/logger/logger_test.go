@@ -0,0 +1,26 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNew(t *testing.T) {
+	prev := Level.Level()
+	defer Level.SetLevel(prev)
+
+	_, err := New(Config{Level: "warn", Format: FormatJSON, Preset: PresetProduction})
+	require.Equal(t, nil, err)
+	assert.Equal(t, zap.WarnLevel, Level.Level())
+	_, err = New(Config{Preset: PresetDevelopment})
+	require.Equal(t, nil, err)
+	assert.Equal(t, zap.DebugLevel, Level.Level())
+
+	for _, cfg := range []Config{{Level: "loud"}, {Format: "xml"}, {Preset: "staging"}} {
+		_, err = New(cfg)
+		assert.NotEqual(t, nil, err)
+	}
+}
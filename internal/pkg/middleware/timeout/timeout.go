@@ -0,0 +1,66 @@
+package timeout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultRoute is key of deadline of routes without their own one
+const DefaultRoute = "*"
+
+// Routes maps route pattern to deadline of its requests, 0 disables deadline
+type Routes map[string]time.Duration
+
+// ParseRoutes reads comma separated 'pattern=duration' pairs, e.g. '*=5s,/order/{id}=2s'
+func ParseRoutes(s string) (Routes, error) {
+	routes := make(Routes)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("Route timeout '%s' is not 'pattern=duration'", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Bad duration of route timeout '%s'", pair)
+		}
+		routes[strings.TrimSpace(pattern)] = d
+	}
+	return routes, nil
+}
+
+// For returns deadline of pattern, DefaultRoute is used when pattern has no own one
+func (r Routes) For(pattern string) time.Duration {
+	d, ok := r[pattern]
+	if !ok {
+		return r[DefaultRoute]
+	}
+	return d
+}
+
+// Handle is chi middleware which sets deadline of pattern to requests
+func (r Routes) Handle(pattern string) func(http.Handler) http.Handler {
+	d := r.For(pattern)
+	return func(next http.Handler) http.Handler {
+		return Handle(next, d)
+	}
+}
+
+// Handle cancels context of request after d, handlers are expected to answer
+// with 504 when their storage calls fail by deadline
+func Handle(next http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
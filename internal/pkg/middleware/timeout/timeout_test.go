@@ -0,0 +1,46 @@
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("*=5s, /order/{id}=2s,/orders/stream=0")
+	require.Equal(t, nil, err)
+	assert.Equal(t, 2*time.Second, routes.For("/order/{id}"))
+	assert.Equal(t, time.Duration(0), routes.For("/orders/stream"))
+	assert.Equal(t, 5*time.Second, routes.For("/metrics"))
+
+	routes, err = ParseRoutes("")
+	require.Equal(t, nil, err)
+	assert.Equal(t, time.Duration(0), routes.For("/metrics"))
+
+	for _, bad := range []string{"/order/{id}", "*=soon", "*=-1s"} {
+		_, err = ParseRoutes(bad)
+		assert.NotEqual(t, nil, err, bad)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+		<-r.Context().Done()
+	})
+	start := time.Now()
+	Handle(next, 10*time.Millisecond).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, ok)
+	assert.WithinDuration(t, start.Add(10*time.Millisecond), deadline, 5*time.Millisecond)
+
+	Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok = r.Context().Deadline()
+	}), 0).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.False(t, ok)
+}
@@ -0,0 +1,29 @@
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+// Run starts embedded nats server with JetStream, it is shut down with the test
+func Run(t *testing.T) *server.Server {
+	opts := &server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true}
+	ns, err := server.NewServer(opts)
+	require.Equal(t, nil, err)
+	go ns.Start()
+	require.True(t, ns.ReadyForConnections(5*time.Second))
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+// Connect opens connection to ns, it is closed with the test
+func Connect(t *testing.T, ns *server.Server) *nats.Conn {
+	nc, err := nats.Connect(ns.ClientURL())
+	require.Equal(t, nil, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
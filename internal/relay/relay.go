@@ -0,0 +1,223 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/outbox"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	PollInterval = 500 * time.Millisecond
+	BatchSize    = 100
+	// PublishTimeout bounds publishing of one batch, its rows are locked meanwhile
+	PublishTimeout = 5 * time.Second
+	// StreamName keeps 'order.stored' events, it is created when missing
+	StreamName = "ORDERS_STORED"
+	// DuplicateWindow is how long JetStream remembers MsgID of relayed row
+	DuplicateWindow = 2 * time.Minute
+)
+
+// Stats describes how far relay is behind of committed orders
+type Stats struct {
+	Relayed          uint64  `json:"relayed"`
+	Failed           uint64  `json:"failed"`
+	Pending          int64   `json:"pending"`
+	OldestPendingSec float64 `json:"oldest_pending_secs"`
+	LastRelayedAt    int64   `json:"last_relayed_at"`
+}
+
+// Relay publishes messages of NATS outbox written by SqlWorker.AddData
+type Relay struct {
+	Worker *postgres.SqlWorker
+	JS     nats.JetStreamContext
+	Log    *zap.SugaredLogger
+
+	streamReady   bool
+	relayed       atomic.Uint64
+	failed        atomic.Uint64
+	lastRelayedAt atomic.Int64
+	mu            sync.Mutex
+	lag           outbox.Lag
+}
+
+func NewRelay(conn *nats.Conn, log *zap.SugaredLogger) (*Relay, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with JetStream context: %w", err)
+	}
+	return &Relay{Worker: &postgres.DBWorker, JS: js, Log: log}, nil
+}
+
+// MsgID is used by JetStream to drop duplicates when row is relayed again after failed commit
+func MsgID(m *outbox.Message) string {
+	return fmt.Sprintf("nats-outbox-%d", m.ID)
+}
+
+func (r *Relay) Run(done chan struct{}, w *sync.WaitGroup) {
+	defer w.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		r.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			r.Log.Infof("Nats outbox relay is stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensureStream creates stream of 'order.stored' events, without it nothing is acknowledged
+func (r *Relay) ensureStream(ctx context.Context) error {
+	if r.streamReady {
+		return nil
+	}
+	_, err := r.JS.StreamInfo(StreamName, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = r.JS.AddStream(&nats.StreamConfig{
+			Name:       StreamName,
+			Subjects:   []string{outbox.StoredSubjectPrefix + ">"},
+			Duplicates: DuplicateWindow,
+		}, nats.Context(ctx))
+	}
+	if err != nil {
+		return fmt.Errorf("Problem with stream '%s': %w", StreamName, err)
+	}
+	r.streamReady = true
+	return nil
+}
+
+// Feed publishes to hub every order relayed by any replica. Each replica reads stream
+// with its own ordered consumer, so orders consumed by other replicas reach its streams too
+// and duplicates of relay after failed commit are dropped by JetStream
+func (r *Relay) Feed(hub *events.Hub) (*nats.Subscription, error) {
+	err := r.ensureStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.JS.Subscribe(outbox.StoredSubjectPrefix+">", func(m *nats.Msg) {
+		var e outbox.StoredEvent
+		err := json.Unmarshal(m.Data, &e)
+		if err != nil {
+			r.Log.Warnw("Problem with decoding of stored event", "subject", m.Subject, "error", err)
+			return
+		}
+		var ord order.Order
+		err = json.Unmarshal(e.Data, &ord)
+		if err != nil {
+			r.Log.Warnw("Problem with decoding of stored order", "order_id", e.OrderID, "error", err)
+			return
+		}
+		// sequence of stream is the same on every replica, so clients resume on any of them
+		meta, err := m.Metadata()
+		if err != nil {
+			r.Log.Warnw("Problem with metadata of stored event", "order_id", e.OrderID, "error", err)
+			hub.Publish(ord)
+			return
+		}
+		hub.PublishID(meta.Sequence.Stream, ord)
+	}, nats.OrderedConsumer(), nats.DeliverNew())
+	if err != nil {
+		return nil, fmt.Errorf("Problem with subscription to '%s': %w", StreamName, err)
+	}
+	return sub, nil
+}
+
+// Tick relays all pending messages and refreshes lag
+func (r *Relay) Tick(ctx context.Context) {
+	for {
+		err := r.ensureStream(ctx)
+		if err != nil {
+			r.failed.Add(1)
+			r.Log.Warnf("Problem with relaying of nats outbox: %s", err.Error())
+			break
+		}
+		n, err := r.Worker.RelayNatsOutbox(ctx, BatchSize, r.publish)
+		// n is number of rows whose relayed mark is committed
+		r.acked(n)
+		if err != nil {
+			r.failed.Add(1)
+			r.Log.Warnf("Problem with relaying of nats outbox: %s", err.Error())
+			break
+		}
+		if n < BatchSize {
+			break
+		}
+	}
+	lag, err := r.Worker.GetNatsOutboxLag(ctx)
+	if err != nil {
+		r.Log.Warnf("Problem with getting of nats outbox lag: %s", err.Error())
+		return
+	}
+	r.mu.Lock()
+	r.lag = lag
+	r.mu.Unlock()
+}
+
+// publish returns number of messages acknowledged by JetStream, rows of them are marked relayed.
+// They are counted by Tick after the mark is committed
+func (r *Relay) publish(ctx context.Context, msgs []outbox.Message) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, PublishTimeout)
+	defer cancel()
+	for i := range msgs {
+		m := &msgs[i]
+		data, err := json.Marshal(outbox.StoredEvent{
+			Type:     webhook.EventOrderStored,
+			OrderID:  m.OrderID,
+			StoredAt: m.CreatedAt,
+			Data:     m.Payload,
+		})
+		if err != nil {
+			return i, fmt.Errorf("Problem with encoding of stored event: %w", err)
+		}
+		msg := nats.NewMsg(m.Subject)
+		msg.Header.Set(nats.MsgIdHdr, MsgID(m))
+		msg.Data = data
+		// ack of duplicate means row was stored by earlier relay whose commit failed
+		_, err = r.JS.PublishMsg(msg, nats.Context(ctx))
+		if err != nil {
+			return i, fmt.Errorf("Problem with publishing of '%s': %w", m.Subject, err)
+		}
+	}
+	return len(msgs), nil
+}
+
+func (r *Relay) acked(n int) {
+	if n == 0 {
+		return
+	}
+	r.relayed.Add(uint64(n))
+	r.lastRelayedAt.Store(time.Now().Unix())
+}
+
+func (r *Relay) Stats() Stats {
+	r.mu.Lock()
+	lag := r.lag
+	r.mu.Unlock()
+	return Stats{
+		Relayed:          r.relayed.Load(),
+		Failed:           r.failed.Load(),
+		Pending:          lag.Pending,
+		OldestPendingSec: lag.OldestPendingSec,
+		LastRelayedAt:    r.lastRelayedAt.Load(),
+	}
+}
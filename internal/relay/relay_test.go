@@ -0,0 +1,155 @@
+package relay
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/events"
+	"github.com/akashipov/L0project/internal/pkg/natstest"
+	"github.com/akashipov/L0project/internal/storage/outbox"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRelay_publish(t *testing.T) {
+	ns := natstest.Run(t)
+	nc := natstest.Connect(t, ns)
+	js, err := nc.JetStream()
+	require.Equal(t, nil, err)
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:       "ORDERS_STORED",
+		Subjects:   []string{outbox.StoredSubjectPrefix + ">"},
+		Duplicates: time.Minute,
+	})
+	require.Equal(t, nil, err)
+
+	r, err := NewRelay(nc, zap.NewNop().Sugar())
+	require.Equal(t, nil, err)
+	msgs := []outbox.Message{
+		{ID: 1, Subject: outbox.StoredSubjectPrefix + "a", OrderID: "a", Payload: json.RawMessage(`{"order_uid":"a"}`)},
+		{ID: 2, Subject: outbox.StoredSubjectPrefix + "b", OrderID: "b", Payload: json.RawMessage(`{"order_uid":"b"}`)},
+	}
+	n, err := r.publish(context.Background(), msgs)
+	require.Equal(t, nil, err)
+	assert.Equal(t, 2, n)
+	// relay after failed commit publishes the same rows again
+	n, err = r.publish(context.Background(), msgs)
+	require.Equal(t, nil, err)
+	assert.Equal(t, 2, n)
+
+	info, err := js.StreamInfo("ORDERS_STORED")
+	require.Equal(t, nil, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+	// rows are counted after their relayed mark is committed
+	assert.Equal(t, uint64(0), r.Stats().Relayed)
+
+	m, err := js.GetMsg("ORDERS_STORED", 1)
+	require.Equal(t, nil, err)
+	assert.Equal(t, "nats-outbox-1", m.Header.Get(nats.MsgIdHdr))
+	var e outbox.StoredEvent
+	require.Equal(t, nil, json.Unmarshal(m.Data, &e))
+	assert.Equal(t, webhook.EventOrderStored, e.Type)
+	assert.Equal(t, "a", e.OrderID)
+	assert.JSONEq(t, `{"order_uid":"a"}`, string(e.Data))
+}
+
+func TestRelay_publishNotStored(t *testing.T) {
+	ns := natstest.Run(t)
+	nc := natstest.Connect(t, ns)
+	r, err := NewRelay(nc, zap.NewNop().Sugar())
+	require.Equal(t, nil, err)
+	msgs := []outbox.Message{
+		{ID: 1, Subject: outbox.StoredSubjectPrefix + "a", OrderID: "a", Payload: json.RawMessage(`{}`)},
+	}
+
+	// without stream nobody acknowledges, so row is kept pending
+	n, err := r.publish(context.Background(), msgs)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, uint64(0), r.Stats().Relayed)
+
+	require.Equal(t, nil, r.ensureStream(context.Background()))
+	info, err := r.JS.StreamInfo(StreamName)
+	require.Equal(t, nil, err)
+	assert.Equal(t, DuplicateWindow, info.Config.Duplicates)
+	n, err = r.publish(context.Background(), msgs)
+	require.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+
+	// publish is bounded by deadline of tx holding locked rows
+	ns.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	n, err = r.publish(ctx, msgs)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, n)
+	assert.Less(t, time.Since(start), PublishTimeout)
+}
+
+func TestRelay_Feed(t *testing.T) {
+	ns := natstest.Run(t)
+	hubs := make([]*events.Hub, 0)
+	relays := make([]*Relay, 0)
+	// every replica feeds its own hub, whichever of them relayed the order
+	for i := 0; i < 2; i++ {
+		r, err := NewRelay(natstest.Connect(t, ns), zap.NewNop().Sugar())
+		require.Equal(t, nil, err)
+		hub := events.NewHub(10)
+		sub, err := r.Feed(hub)
+		require.Equal(t, nil, err)
+		t.Cleanup(func() { sub.Unsubscribe() })
+		relays = append(relays, r)
+		hubs = append(hubs, hub)
+	}
+	msgs := []outbox.Message{
+		{ID: 1, Subject: outbox.StoredSubjectPrefix + "a", OrderID: "a", Payload: json.RawMessage(`{"order_uid":"a","entry":"WBIL"}`)},
+		{ID: 2, Subject: outbox.StoredSubjectPrefix + "b", OrderID: "b", Payload: json.RawMessage(`{"order_uid":"b"}`)},
+	}
+	n, err := relays[0].publish(context.Background(), msgs)
+	require.Equal(t, nil, err)
+	assert.Equal(t, 2, n)
+	// relay after failed commit is not seen by streams
+	n, err = relays[1].publish(context.Background(), msgs[:1])
+	require.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+
+	for _, hub := range hubs {
+		require.Eventually(t, func() bool {
+			return hub.LastID() == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		missed, _, cancel := hub.SubscribeFrom(0)
+		cancel()
+		require.Equal(t, 2, len(missed))
+		assert.Equal(t, "a", missed[0].Order.OrderID)
+		assert.Equal(t, "WBIL", missed[0].Order.Entry)
+		assert.Equal(t, "b", missed[1].Order.OrderID)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint64(2), hubs[0].LastID())
+	assert.Equal(t, uint64(2), hubs[1].LastID())
+}
+
+func TestRelay_TickNotCommitted(t *testing.T) {
+	ns := natstest.Run(t)
+	r, err := NewRelay(natstest.Connect(t, ns), zap.NewNop().Sugar())
+	require.Equal(t, nil, err)
+	db, err := sql.Open("postgres", "postgres://l0:l0@127.0.0.1:1/l0?sslmode=disable&connect_timeout=1")
+	require.Equal(t, nil, err)
+	defer db.Close()
+	r.Worker = &postgres.SqlWorker{DB: db}
+
+	// rows are not counted while relayed mark is not committed
+	r.Tick(context.Background())
+	st := r.Stats()
+	assert.Equal(t, uint64(0), st.Relayed)
+	assert.Equal(t, uint64(1), st.Failed)
+	assert.Equal(t, int64(0), st.LastRelayedAt)
+}
@@ -3,9 +3,9 @@ package server
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/akashipov/L0project/internal/arguments"
 	"github.com/akashipov/L0project/internal/handlers"
@@ -27,8 +27,18 @@ func NewServer(ctx context.Context, log zap.SugaredLogger) (*Server, error) {
 	}
 	once.Do(
 		func() {
-			srv = &http.Server{Addr: arguments.HPServer, Handler: handlers.ServerRouter(&log)}
-			cache.InitCache(ctx, &log)
+			srv = &http.Server{
+				Addr:              arguments.HPServer,
+				Handler:           handlers.ServerRouter(&log),
+				ReadTimeout:       time.Duration(arguments.HTTPReadTimeoutSecs) * time.Second,
+				ReadHeaderTimeout: time.Duration(arguments.HTTPReadHeaderTimeoutSecs) * time.Second,
+				WriteTimeout:      time.Duration(arguments.HTTPWriteTimeoutSecs) * time.Second,
+				IdleTimeout:       time.Duration(arguments.HTTPIdleTimeoutSecs) * time.Second,
+			}
+			srv.RegisterOnShutdown(handlers.CloseStreams)
+			// server is live while cache is warmed, readiness waits for it
+			cache.SetupCache(ctx, &log)
+			go cache.WarmCache(ctx, &log)
 		},
 	)
 	if srv == nil {
@@ -41,18 +51,26 @@ func NewServer(ctx context.Context, log zap.SugaredLogger) (*Server, error) {
 }
 
 func (s *Server) RunServer(done chan struct{}, w *sync.WaitGroup) {
-	w.Add(1)
+	defer w.Done()
 	if s.Srv == nil {
-		fmt.Println("Need to init server first")
+		s.Log.Errorf("Need to init server first")
 		return
 	}
 	go func() {
-		s.Srv.ListenAndServe()
-		fmt.Println("Server is stopped")
-		w.Done()
+		err := s.Srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Log.Errorw("Http server error", "error", err)
+		}
 	}()
 	<-done
-	fmt.Println("Server is stopping...")
-	s.Srv.Close()
-	w.Done()
+	timeout := time.Duration(arguments.ShutdownHTTPSecs) * time.Second
+	s.Log.Infow("Http server is stopping...", "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Srv.Shutdown(ctx)
+	if err != nil {
+		s.Log.Warnw("Http requests are not finished in time, connections are closed", "error", err)
+		s.Srv.Close()
+	}
+	s.Log.Infof("Http server is stopped")
 }
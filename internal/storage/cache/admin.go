@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/akashipov/L0project/internal/arguments"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+)
+
+// Report joins all counters of cache
+type Report struct {
+	Stats
+	FlightStats
+	RefreshStats
+	NegativeStats
+}
+
+func GetReport() Report {
+	var st Stats
+	if LRUCache != nil {
+		st = LRUCache.Stats()
+	}
+	return Report{st, LoadStats(), GetRefreshStats(), GetNegativeStats()}
+}
+
+// KeyInfo describes cached order without its data
+type KeyInfo struct {
+	Key      string    `json:"key"`
+	Bytes    int       `json:"bytes"`
+	StoredAt time.Time `json:"stored_at"`
+	// TTLSecs is time left until entry expires, -1 when it doesn't expire
+	TTLSecs float64 `json:"ttl_secs"`
+	Stale   bool    `json:"stale"`
+}
+
+func keyInfo(key string, e Entry, now time.Time) KeyInfo {
+	info := KeyInfo{
+		Key:      key,
+		Bytes:    len(e.Data),
+		StoredAt: e.StoredAt,
+		TTLSecs:  -1,
+		Stale:    refresh.state(e) != fresh,
+	}
+	if !e.ExpiresAt.IsZero() {
+		info.TTLSecs = e.ExpiresAt.Sub(now).Seconds()
+		if info.TTLSecs < 0 {
+			info.TTLSecs = 0
+		}
+	}
+	return info
+}
+
+// Inspect returns cached keys from the most recently used without changing their recency
+func Inspect() []KeyInfo {
+	now := time.Now()
+	keys := LRUCache.Keys()
+	infos := make([]KeyInfo, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		e, ok := LRUCache.Peek(keys[i])
+		if !ok {
+			continue
+		}
+		infos = append(infos, keyInfo(keys[i], e, now))
+	}
+	return infos
+}
+
+func InspectKey(key string) (KeyInfo, bool) {
+	e, ok := LRUCache.Peek(key)
+	if !ok {
+		return KeyInfo{}, false
+	}
+	return keyInfo(key, e, time.Now()), true
+}
+
+// Evict drops order only from cache of this replica
+func Evict(key string) bool {
+	return LRUCache.Delete(key)
+}
+
+func Purge() {
+	LRUCache.Purge()
+	if NotFound != nil {
+		NotFound.Purge()
+	}
+}
+
+// Rewarm loads orders chosen by strategy with configured concurrency and time budget
+func Rewarm(ctx context.Context, strategy string) (WarmupStats, error) {
+	window := time.Hour * time.Duration(arguments.CacheWarmupWindowHours)
+	ids, err := postgres.DBWorker.GetWarmupIDs(ctx, strategy, window, arguments.CacheSize)
+	if err != nil {
+		return WarmupStats{}, err
+	}
+	return Warmup(
+		ctx, ids, arguments.CacheWarmupConcurrency,
+		time.Second*time.Duration(arguments.CacheWarmupBudgetSecs),
+	), nil
+}
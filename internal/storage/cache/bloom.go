@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Bloom is a set of order uids without false negatives,
+// so order which is not in it is definitely unknown
+type Bloom struct {
+	mu       sync.RWMutex
+	bits     []uint64
+	m        uint64
+	k        uint64
+	inserted atomic.Uint64
+	// ready is set when all stored orders were added
+	ready atomic.Bool
+	// next is filled by Rebuild, keys added meanwhile go to both
+	next         []uint64
+	nextInserted uint64
+}
+
+// NewBloom sizes filter for expected number of orders with false positive rate p
+func NewBloom(expected int, p float64) *Bloom {
+	if expected < 1 {
+		expected = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(expected) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Round(float64(m) / float64(expected) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// hashes returns two halves of fnv64a, positions are h1 + i*h2
+func (b *Bloom) hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+func (b *Bloom) set(bits []uint64, key string) {
+	h1, h2 := b.hashes(key)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *Bloom) Add(key string) {
+	b.mu.Lock()
+	b.set(b.bits, key)
+	if b.next != nil {
+		b.set(b.next, key)
+		b.nextInserted++
+	}
+	b.mu.Unlock()
+	b.inserted.Add(1)
+}
+
+// Rebuild replaces keys of filter with ones added by fill, keys missed by Add
+// e.g. of orders stored by other replica are added again. Filter is kept as is when fill fails
+func (b *Bloom) Rebuild(fill func(add func(key string)) error) error {
+	b.mu.Lock()
+	b.next = make([]uint64, len(b.bits))
+	b.nextInserted = 0
+	b.mu.Unlock()
+	err := fill(func(key string) {
+		b.mu.Lock()
+		b.set(b.next, key)
+		b.nextInserted++
+		b.mu.Unlock()
+	})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.bits = b.next
+		b.inserted.Store(b.nextInserted)
+	}
+	b.next = nil
+	return err
+}
+
+// MayContain returns false only for key which was never added
+func (b *Bloom) MayContain(key string) bool {
+	h1, h2 := b.hashes(key)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) SetReady() {
+	b.ready.Store(true)
+}
+
+// Rejects tells that order is definitely unknown,
+// filter doesn't reject anything until it is ready
+func (b *Bloom) Rejects(key string) bool {
+	return b.ready.Load() && !b.MayContain(key)
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ByteLRU limits total size of kept orders in bytes instead of number of them,
+// the least recently used orders are evicted until new one fits
+type ByteLRU struct {
+	mu            sync.Mutex
+	ll            *list.List
+	items         map[string]*list.Element
+	ttl           time.Duration
+	maxBytes      int64
+	maxEntryBytes int64
+	bytes         int64
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	rejected      uint64
+}
+
+type byteItem struct {
+	key       string
+	value     Entry
+	size      int64
+	expiresAt time.Time
+}
+
+// NewByteLRU creates cache with budget of maxBytes, entries bigger than
+// maxEntryBytes are not saved, zero maxEntryBytes allows entry of whole budget
+func NewByteLRU(maxBytes int64, maxEntryBytes int64, ttl time.Duration) *ByteLRU {
+	return &ByteLRU{
+		ll:            list.New(),
+		items:         make(map[string]*list.Element),
+		ttl:           ttl,
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+	}
+}
+
+func entrySize(key string, value Entry) int64 {
+	size := len(key) + len(value.Data)
+	for _, encoded := range value.Encoded {
+		size += len(encoded)
+	}
+	return int64(size)
+}
+
+func (c *ByteLRU) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return Entry{}, false
+	}
+	item := el.Value.(*byteItem)
+	if c.ttl > 0 && time.Now().After(item.expiresAt) {
+		c.remove(el)
+		c.evictions++
+		c.misses++
+		return Entry{}, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits++
+	return item.value, true
+}
+
+func (c *ByteLRU) Set(key string, value Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := entrySize(key, value)
+	if size > c.maxBytes || (c.maxEntryBytes > 0 && size > c.maxEntryBytes) {
+		c.rejected++
+		// previous value of order must not be served instead of the new one
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+		return
+	}
+	expiresAt := time.Now().Add(c.ttl)
+	value.ExpiresAt = time.Time{}
+	if c.ttl > 0 {
+		value.ExpiresAt = expiresAt
+	}
+	if el, ok := c.items[key]; ok {
+		item := el.Value.(*byteItem)
+		c.bytes += size - item.size
+		item.value, item.size, item.expiresAt = value, size, expiresAt
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&byteItem{key: key, value: value, size: size, expiresAt: expiresAt})
+		c.bytes += size
+	}
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *ByteLRU) remove(el *list.Element) {
+	item := c.ll.Remove(el).(*byteItem)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+}
+
+func (c *ByteLRU) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.remove(el)
+	}
+	return ok
+}
+
+func (c *ByteLRU) Peek(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return Entry{}, false
+	}
+	item := el.Value.(*byteItem)
+	if c.ttl > 0 && time.Now().After(item.expiresAt) {
+		return Entry{}, false
+	}
+	return item.value, true
+}
+
+func (c *ByteLRU) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, c.ll.Len())
+	for el := c.ll.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(*byteItem).key)
+	}
+	return keys
+}
+
+func (c *ByteLRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+func (c *ByteLRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Rejected:  c.rejected,
+		Size:      c.ll.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
@@ -4,41 +4,347 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/akashipov/L0project/internal/arguments"
+	customerrors "github.com/akashipov/L0project/internal/errors"
 	"github.com/akashipov/L0project/internal/storage/postgres"
-	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/akashipov/L0project/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-var LRUCache *expirable.LRU[string, []byte]
+const (
+	TypeLRU     = "lru"
+	TypeSharded = "sharded"
+	TypeBytes   = "bytes"
+)
+
+// Entry is order in json format with time when it was loaded from Psql db
+type Entry struct {
+	Data     []byte
+	StoredAt time.Time
+	// ExpiresAt is set by cache on Set, it is zero when entries don't expire
+	ExpiresAt time.Time
+	// Encoded keeps Data compressed by content encodings, e.g. 'gzip'
+	Encoded map[string][]byte
+}
+
+// Cache keeps orders by order uid
+type Cache interface {
+	Get(key string) (Entry, bool)
+	Set(key string, value Entry)
+	// Delete returns false when key is absent
+	Delete(key string) bool
+	// Peek returns entry without updating of recency and stats
+	Peek(key string) (Entry, bool)
+	// Keys are ordered from the least recently used
+	Keys() []string
+	Purge()
+	Stats() Stats
+}
+
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	// Rejected counts entries over max size which were not saved
+	Rejected uint64 `json:"rejected"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	// Bytes and MaxBytes are reported by cache sized in bytes
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+func (s Stats) Add(o Stats) Stats {
+	return Stats{
+		Hits:      s.Hits + o.Hits,
+		Misses:    s.Misses + o.Misses,
+		Evictions: s.Evictions + o.Evictions,
+		Rejected:  s.Rejected + o.Rejected,
+		Size:      s.Size + o.Size,
+		Capacity:  s.Capacity + o.Capacity,
+		Bytes:     s.Bytes + o.Bytes,
+		MaxBytes:  s.MaxBytes + o.MaxBytes,
+	}
+}
+
+var LRUCache Cache
+
+var loads = newFlight()
+
+var refresh = newRefresher(0, 0, 1)
+
+// load is replaced in tests to not depend on Psql db
+var load = loadOrder
+
+// LoadStats returns how many orders were loaded from Psql db on cache misses
+// and how many misses were served by a load already in progress
+func LoadStats() FlightStats {
+	return loads.Stats()
+}
+
+// GetRefreshStats returns counters of stale values served and their background refreshes
+func GetRefreshStats() RefreshStats {
+	return refresh.Stats()
+}
 
+type Config struct {
+	Type string
+	// Size is max number of entries, it is not used by cache sized in bytes
+	Size   int
+	Shards int
+	TTL    time.Duration
+	// MaxBytes is budget of cache sized in bytes,
+	// entries over MaxEntryBytes are rejected by it
+	MaxBytes      int64
+	MaxEntryBytes int64
+}
+
+// New creates cache of the type selected by configuration
+func New(cfg Config) (Cache, error) {
+	switch cfg.Type {
+	case TypeLRU:
+		return NewExpirableLRU(cfg.Size, cfg.TTL), nil
+	case TypeSharded:
+		return NewSharded(cfg.Shards, cfg.Size, cfg.TTL), nil
+	case TypeBytes:
+		if cfg.MaxBytes <= 0 {
+			return nil, fmt.Errorf("Cache of type '%s' needs positive max bytes", cfg.Type)
+		}
+		return NewByteLRU(cfg.MaxBytes, cfg.MaxEntryBytes, cfg.TTL), nil
+	}
+	return nil, fmt.Errorf("Unknown cache type '%s'", cfg.Type)
+}
+
+// retention is TTL of cache store, values are kept after time limit, so they are
+// answered while Psql db is unavailable. Freshness is checked by refresher
+func retention(limit, keep time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	return limit + keep
+}
+
+// warmed is set when cache is filled by snapshot or warmup
+var warmed atomic.Bool
+
+func Warmed() bool {
+	return warmed.Load()
+}
+
+// InitCache creates cache and fills it before return
 func InitCache(ctx context.Context, log *zap.SugaredLogger) {
-	LRUCache = expirable.NewLRU[string, []byte](arguments.CacheSize, nil, time.Second*time.Duration(arguments.CacheTimeLimitSecs))
-	tx, err := postgres.DBWorker.CreateTx()
+	SetupCache(ctx, log)
+	WarmCache(ctx, log)
+}
+
+// SetupCache creates empty cache, orders are served from Psql db until WarmCache is done
+func SetupCache(ctx context.Context, log *zap.SugaredLogger) {
+	var err error
+	warmed.Store(false)
+	ttl := retention(
+		time.Second*time.Duration(arguments.CacheTimeLimitSecs),
+		time.Second*time.Duration(arguments.CacheRetentionSecs),
+	)
+	LRUCache, err = New(Config{
+		Type:          arguments.CacheType,
+		Size:          arguments.CacheSize,
+		Shards:        arguments.CacheShards,
+		TTL:           ttl,
+		MaxBytes:      arguments.CacheMaxBytes,
+		MaxEntryBytes: arguments.CacheMaxEntryBytes,
+	})
 	if err != nil {
-		log.Infof("Problem with getting tx:", err.Error())
+		log.Warnf("Problem with cache creation, '%s' is used: %s", TypeLRU, err.Error())
+		LRUCache = NewExpirableLRU(arguments.CacheSize, ttl)
 	}
-	ids, err := postgres.DBWorker.GetHistoryInterval(ctx, tx)
+	refresh = newRefresher(
+		time.Second*time.Duration(arguments.CacheSoftLimitSecs),
+		time.Second*time.Duration(arguments.CacheTimeLimitSecs),
+		arguments.CacheRefreshConcurrency,
+	)
+	Encodings, err = ParseEncodings(arguments.CacheEncodings)
 	if err != nil {
-		log.Infof("Problem with initialization of cache from Psql db:", err.Error())
+		log.Warnf("Problem with cache encodings, '%s' is used: %s", EncodingGzip, err.Error())
+		Encodings = []string{EncodingGzip}
+	}
+	NotFound = nil
+	if arguments.CacheNegativeSize > 0 {
+		NotFound = NewExpirableLRU(
+			arguments.CacheNegativeSize, time.Second*time.Duration(arguments.CacheNegativeTimeLimitSecs),
+		)
 	}
-	for _, id := range ids {
-		ord, cErr := postgres.DBWorker.GetDataByID(ctx, id)
-		if err != nil {
-			err = errors.Join(cErr, err)
+	Known = nil
+	if arguments.CacheBloomExpected > 0 {
+		Known = NewBloom(arguments.CacheBloomExpected, arguments.CacheBloomFalsePositive)
+		go buildKnown(ctx, log, Known, time.Second*time.Duration(arguments.CacheBloomRebuildSecs))
+	}
+}
+
+// WarmCache fills cache from snapshot or by warmup strategy
+func WarmCache(ctx context.Context, log *zap.SugaredLogger) {
+	defer warmed.Store(true)
+	if arguments.CacheSnapshotPath != "" {
+		keys, err := LoadSnapshot(arguments.CacheSnapshotPath, LRUCache)
+		if err == nil {
+			log.Infof("LRU cache created from snapshot with %d orders!", len(keys))
+			go reconcile(ctx, log, keys)
+			return
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Infof("Snapshot of cache is ignored: %s", err.Error())
+		}
+	}
+	window := time.Hour * time.Duration(arguments.CacheWarmupWindowHours)
+	ids, err := postgres.DBWorker.GetWarmupIDs(ctx, arguments.CacheWarmupStrategy, window, arguments.CacheSize)
+	if err != nil {
+		log.Warnf("Problem with initialization of cache from Psql db: %s", err.Error())
+	}
+	st := Warmup(
+		ctx, ids, arguments.CacheWarmupConcurrency,
+		time.Second*time.Duration(arguments.CacheWarmupBudgetSecs),
+	)
+	log.Infof(
+		"LRU cache created! Warmup '%s' loaded %d of %d orders in %s, failed %d, skipped %d",
+		arguments.CacheWarmupStrategy, st.Loaded, st.Requested, st.Duration, st.Failed, st.Skipped,
+	)
+	n, err := postgres.DBWorker.DeleteOrderAccessBefore(ctx, time.Now().Add(-window))
+	if err != nil {
+		log.Warnf("Problem with removing of old order access stats: %s", err.Error())
+	} else if n > 0 {
+		log.Infof("%d old order access buckets were removed", n)
+	}
+}
+
+// reconcile reloads orders restored from snapshot,
+// orders removed from Psql db while server was down are evicted
+func reconcile(ctx context.Context, log *zap.SugaredLogger, keys []string) {
+	var stale int
+	for _, id := range keys {
+		_, cErr := loads.Do(ctx, id, func(ctx context.Context) (Entry, *customerrors.CustomError) {
+			return load(ctx, id)
+		})
+		if cErr == nil {
 			continue
 		}
-		data, err := json.MarshalIndent(ord, "", "    ")
-		if err != nil {
-			err = errors.Join(cErr, err)
+		if cErr.Status == http.StatusNotFound {
+			LRUCache.Delete(id)
+			stale++
 			continue
 		}
-		LRUCache.Add(id, data)
+		log.Warnw("Problem with reconciliation", "order_id", id, "error", cErr.Message)
+	}
+	log.Infof("Cache snapshot is reconciled with Psql db, %d orders of %d were removed", stale, len(keys))
+}
+
+// Invalidate drops cached copy of order after it was changed,
+// order may be stored now, so it is not unknown anymore
+func Invalidate(id string) {
+	if LRUCache != nil {
+		LRUCache.Delete(id)
+	}
+	if NotFound != nil {
+		NotFound.Delete(id)
+	}
+	if Known != nil {
+		Known.Add(id)
+	}
+}
+
+// GetOrder returns order in json format from cache,
+// on miss it is loaded from Psql db and saved to cache
+func GetOrder(ctx context.Context, id string) ([]byte, *customerrors.CustomError) {
+	e, cErr := GetOrderEntry(ctx, id)
+	if cErr != nil {
+		return nil, cErr
 	}
+	return e.Data, nil
+}
+
+// GetOrderEntry returns order with its compressed variants.
+// Concurrent misses of the same id share one load.
+// Value older than soft time limit is returned at once and refreshed in background.
+// Unknown orders are rejected without query to Psql db
+func GetOrderEntry(ctx context.Context, id string) (Entry, *customerrors.CustomError) {
+	e, _, cErr := LookupOrder(ctx, id)
+	return e, cErr
+}
+
+// Lookup results reported to clients with X-Cache header
+const (
+	LookupHit      = "hit"
+	LookupStale    = "stale"
+	LookupMiss     = "miss"
+	LookupNegative = "negative"
+)
+
+// LookupOrder works like GetOrderEntry and tells how order was found
+func LookupOrder(ctx context.Context, id string) (Entry, string, *customerrors.CustomError) {
+	ctx, span := tracing.Start(ctx, "cache lookup", trace.WithAttributes(tracing.OrderID(id)))
+	defer span.End()
+	e, lookup, cErr := lookupOrder(ctx, id)
+	span.SetAttributes(attribute.String("cache.result", lookup))
+	if cErr != nil && cErr.Status >= http.StatusInternalServerError {
+		tracing.Fail(span, cErr)
+	}
+	return e, lookup, cErr
+}
+
+func lookupOrder(ctx context.Context, id string) (Entry, string, *customerrors.CustomError) {
+	e, ok := LRUCache.Get(id)
+	if ok {
+		switch refresh.state(e) {
+		case fresh:
+			LRUCache.Set(id, e)
+			return e, LookupHit, nil
+		case stale:
+			refresh.staleServed.Add(1)
+			if !postgres.DBWorker.Unavailable() {
+				refresh.start(id)
+			}
+			return e, LookupStale, nil
+		}
+		// expired copy is better than no answer while Psql db is unavailable
+		if postgres.DBWorker.Unavailable() {
+			refresh.staleServed.Add(1)
+			return e, LookupStale, nil
+		}
+		LRUCache.Delete(id)
+	}
+	cErr := checkUnknown(id)
+	if cErr != nil {
+		return Entry{}, LookupNegative, cErr
+	}
+	e, cErr = loads.Do(ctx, id, func(ctx context.Context) (Entry, *customerrors.CustomError) {
+		return load(ctx, id)
+	})
+	rememberUnknown(id, cErr)
+	return e, LookupMiss, cErr
+}
+
+func loadOrder(ctx context.Context, id string) (Entry, *customerrors.CustomError) {
+	ord, cErr := postgres.DBWorker.GetDataByID(ctx, id)
+	if cErr != nil {
+		return Entry{}, cErr
+	}
+	data, err := json.MarshalIndent(ord, "", "    ")
 	if err != nil {
-		log.Infof("Problem with some ids:", err.Error())
+		return Entry{}, &customerrors.CustomError{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		}
 	}
-	log.Infof("LRU cache created!")
+	e := NewEntry(data)
+	LRUCache.Set(id, e)
+	return e, nil
 }
@@ -0,0 +1,733 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/breaker"
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		maxBytes int64
+		wantErr  bool
+	}{
+		{name: "lru", kind: TypeLRU, wantErr: false},
+		{name: "sharded", kind: TypeSharded, wantErr: false},
+		{name: "bytes", kind: TypeBytes, maxBytes: 1024, wantErr: false},
+		{name: "bytes_without_budget", kind: TypeBytes, wantErr: true},
+		{name: "unknown", kind: "redis", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(Config{Type: tt.kind, Size: 10, Shards: 4, TTL: time.Minute, MaxBytes: tt.maxBytes})
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantErr, c == nil)
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, kind := range []string{TypeLRU, TypeSharded} {
+		t.Run(kind, func(t *testing.T) {
+			c, err := New(Config{Type: kind, Size: 4, Shards: 2, TTL: time.Minute})
+			require.Equal(t, nil, err)
+			c.Set("a", Entry{Data: []byte("1")})
+			v, ok := c.Get("a")
+			assert.True(t, ok)
+			assert.Equal(t, []byte("1"), v.Data)
+			_, ok = c.Get("b")
+			assert.False(t, ok)
+
+			assert.True(t, c.Delete("a"))
+			assert.False(t, c.Delete("a"))
+			for i := 0; i < 3; i++ {
+				c.Set(fmt.Sprint(i), Entry{Data: []byte("v")})
+			}
+			c.Purge()
+			st := c.Stats()
+			assert.Equal(t, uint64(1), st.Hits)
+			assert.Equal(t, uint64(1), st.Misses)
+			assert.Equal(t, uint64(0), st.Evictions)
+			assert.Equal(t, 0, st.Size)
+			assert.Equal(t, 4, st.Capacity)
+		})
+	}
+}
+
+func TestCache_Evictions(t *testing.T) {
+	for _, kind := range []string{TypeLRU, TypeSharded} {
+		t.Run(kind, func(t *testing.T) {
+			c, err := New(Config{Type: kind, Size: 8, Shards: 2, TTL: time.Minute})
+			require.Equal(t, nil, err)
+			for i := 0; i < 100; i++ {
+				c.Set(fmt.Sprint(i), Entry{Data: []byte("v")})
+			}
+			st := c.Stats()
+			assert.LessOrEqual(t, st.Size, 8)
+			assert.Equal(t, uint64(100-st.Size), st.Evictions)
+		})
+	}
+}
+
+func TestSharded_Concurrent(t *testing.T) {
+	c := NewSharded(8, 1000, time.Minute)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint(i % 100)
+				c.Set(key, Entry{Data: []byte(key)})
+				v, ok := c.Get(key)
+				if ok {
+					assert.Equal(t, key, string(v.Data))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(8000), c.Stats().Hits+c.Stats().Misses)
+}
+
+func TestFlight_Do(t *testing.T) {
+	f := newFlight()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	load := func(ctx context.Context) (Entry, *customerrors.CustomError) {
+		calls.Add(1)
+		<-release
+		return Entry{Data: []byte("order")}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan []byte, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, cErr := f.Do(context.Background(), "id", load)
+			assert.Nil(t, cErr)
+			results <- e.Data
+		}()
+	}
+	// caller with short deadline stops waiting, the load keeps running for others
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Eventually(t, func() bool { return f.Stats().CoalescedLoads == 9 }, time.Second, time.Millisecond)
+	_, cErr := f.Do(ctx, "id", load)
+	require.NotNil(t, cErr)
+	assert.Equal(t, http.StatusGatewayTimeout, int(cErr.Status))
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for data := range results {
+		assert.Equal(t, []byte("order"), data)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, FlightStats{Loads: 1, CoalescedLoads: 10}, f.Stats())
+
+	// finished load is not reused
+	e, cErr := f.Do(context.Background(), "id", load)
+	assert.Nil(t, cErr)
+	assert.Equal(t, []byte("order"), e.Data)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestFlight_Do_Abandoned(t *testing.T) {
+	f := newFlight()
+	cancelled := make(chan struct{})
+	load := func(ctx context.Context) (Entry, *customerrors.CustomError) {
+		<-ctx.Done()
+		close(cancelled)
+		return Entry{}, &customerrors.CustomError{Message: ctx.Err().Error(), Status: http.StatusGatewayTimeout}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, cErr := f.Do(ctx, "id", load)
+	require.NotNil(t, cErr)
+	assert.Equal(t, http.StatusGatewayTimeout, int(cErr.Status))
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Load was not cancelled after its only caller had gone")
+	}
+
+	// next caller doesn't join cancelled load
+	e, cErr := f.Do(context.Background(), "id", func(ctx context.Context) (Entry, *customerrors.CustomError) {
+		return Entry{Data: []byte("order")}, nil
+	})
+	assert.Nil(t, cErr)
+	assert.Equal(t, []byte("order"), e.Data)
+	assert.Equal(t, uint64(2), f.Stats().Loads)
+}
+
+// useCache replaces globals of package for one test
+func useCache(t *testing.T, soft, hard time.Duration, concurrency int, fn func(ctx context.Context) ([]byte, *customerrors.CustomError)) {
+	prevCache, prevRefresh, prevLoad, prevLoads := LRUCache, refresh, load, loads
+	prevNotFound, prevKnown := NotFound, Known
+	t.Cleanup(func() {
+		LRUCache, refresh, load, loads = prevCache, prevRefresh, prevLoad, prevLoads
+		NotFound, Known = prevNotFound, prevKnown
+	})
+	NotFound, Known = nil, nil
+	negativeHits.Store(0)
+	bloomRejected.Store(0)
+	LRUCache = NewExpirableLRU(10, time.Minute)
+	refresh = newRefresher(soft, hard, concurrency)
+	loads = newFlight()
+	load = func(ctx context.Context, id string) (Entry, *customerrors.CustomError) {
+		data, cErr := fn(ctx)
+		if cErr != nil {
+			return Entry{}, cErr
+		}
+		e := Entry{Data: data, StoredAt: time.Now()}
+		LRUCache.Set(id, e)
+		return e, nil
+	}
+}
+
+func TestGetOrder_StaleWhileRevalidate(t *testing.T) {
+	loadErr := &customerrors.CustomError{Message: "db is down", Status: http.StatusInternalServerError}
+	tests := []struct {
+		name      string
+		age       time.Duration
+		loadErr   *customerrors.CustomError
+		want      string
+		wantCErr  bool
+		wantAfter string
+	}{
+		{name: "fresh", age: 0, want: "old", wantAfter: "old"},
+		{name: "stale_refreshed", age: 2 * time.Second, want: "old", wantAfter: "new"},
+		{name: "stale_refresh_failed", age: 2 * time.Second, loadErr: loadErr, want: "old", wantAfter: "old"},
+		{name: "expired", age: 20 * time.Second, want: "new", wantAfter: "new"},
+		{name: "expired_load_failed", age: 20 * time.Second, loadErr: loadErr, wantCErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCache(t, time.Second, 10*time.Second, 2, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+				if tt.loadErr != nil {
+					return nil, tt.loadErr
+				}
+				return []byte("new"), nil
+			})
+			LRUCache.Set("id", Entry{Data: []byte("old"), StoredAt: time.Now().Add(-tt.age)})
+
+			data, cErr := GetOrder(context.Background(), "id")
+			require.Equal(t, tt.wantCErr, cErr != nil)
+			assert.Equal(t, tt.want, string(data))
+			if tt.wantCErr {
+				return
+			}
+			require.Eventually(t, func() bool {
+				_, busy := refresh.running.Load("id")
+				return !busy
+			}, time.Second, time.Millisecond)
+			e, ok := LRUCache.Get("id")
+			require.True(t, ok)
+			assert.Equal(t, tt.wantAfter, string(e.Data))
+		})
+	}
+}
+
+func TestLookupOrder_Degraded(t *testing.T) {
+	var calls atomic.Int32
+	useCache(t, time.Second, 10*time.Second, 2, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		calls.Add(1)
+		return []byte("new"), nil
+	})
+	prevBreaker := postgres.DBWorker.Breaker
+	t.Cleanup(func() { postgres.DBWorker.Breaker = prevBreaker })
+	postgres.DBWorker.Breaker = breaker.New(breaker.Config{Failures: 1, OpenTimeout: time.Minute})
+	done, err := postgres.DBWorker.Breaker.Allow()
+	require.Equal(t, nil, err)
+	done(true)
+
+	LRUCache.Set("stale", Entry{Data: []byte("old"), StoredAt: time.Now().Add(-2 * time.Second)})
+	LRUCache.Set("expired", Entry{Data: []byte("old"), StoredAt: time.Now().Add(-20 * time.Second)})
+	for _, id := range []string{"stale", "expired"} {
+		e, lookup, cErr := LookupOrder(context.Background(), id)
+		require.Nil(t, cErr, id)
+		assert.Equal(t, LookupStale, lookup, id)
+		assert.Equal(t, "old", string(e.Data), id)
+	}
+	// refresh is not started while Psql db is unavailable
+	assert.Equal(t, int32(0), calls.Load())
+	assert.Equal(t, uint64(2), refresh.Stats().StaleServed)
+}
+
+func TestLookupOrder_DegradedAfterTimeLimit(t *testing.T) {
+	hard := 50 * time.Millisecond
+	// soft time limit is disabled, hard one is checked by refresher anyway
+	useCache(t, 0, hard, 2, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		return []byte("new"), nil
+	})
+	LRUCache = NewExpirableLRU(10, retention(hard, time.Minute))
+	LRUCache.Set("a", Entry{Data: []byte("old"), StoredAt: time.Now()})
+	LRUCache.Set("b", Entry{Data: []byte("old"), StoredAt: time.Now()})
+	time.Sleep(2 * hard)
+
+	// Psql db fails after hard time limit of cached orders
+	prevBreaker := postgres.DBWorker.Breaker
+	t.Cleanup(func() { postgres.DBWorker.Breaker = prevBreaker })
+	postgres.DBWorker.Breaker = breaker.New(breaker.Config{Failures: 1, OpenTimeout: time.Minute})
+	done, err := postgres.DBWorker.Breaker.Allow()
+	require.Equal(t, nil, err)
+	done(true)
+	e, lookup, cErr := LookupOrder(context.Background(), "a")
+	require.Nil(t, cErr)
+	assert.Equal(t, LookupStale, lookup)
+	assert.Equal(t, "old", string(e.Data))
+
+	// expired value is not served while Psql db is available
+	postgres.DBWorker.Breaker = nil
+	e, lookup, cErr = LookupOrder(context.Background(), "b")
+	require.Nil(t, cErr)
+	assert.Equal(t, LookupMiss, lookup)
+	assert.Equal(t, "new", string(e.Data))
+}
+
+func TestRetention(t *testing.T) {
+	assert.Equal(t, 305*time.Second, retention(5*time.Second, 300*time.Second))
+	assert.Equal(t, 5*time.Second, retention(5*time.Second, 0))
+	// values without time limit never expire
+	assert.Equal(t, time.Duration(0), retention(0, 300*time.Second))
+}
+
+func TestGetOrder_RefreshConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	useCache(t, time.Second, time.Minute, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		calls.Add(1)
+		<-release
+		return []byte("new"), nil
+	})
+	old := time.Now().Add(-2 * time.Second)
+	LRUCache.Set("a", Entry{Data: []byte("old"), StoredAt: old})
+	LRUCache.Set("b", Entry{Data: []byte("old"), StoredAt: old})
+
+	for _, id := range []string{"a", "a", "b"} {
+		data, cErr := GetOrder(context.Background(), id)
+		require.Nil(t, cErr)
+		assert.Equal(t, "old", string(data))
+	}
+	close(release)
+	require.Eventually(t, func() bool { return refresh.Stats().Refreshes == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, RefreshStats{StaleServed: 3, Refreshes: 1, RefreshesSkipped: 1}, refresh.Stats())
+}
+
+func TestByteLRU(t *testing.T) {
+	entry := func(n int) Entry {
+		return Entry{Data: make([]byte, n)}
+	}
+	c := NewByteLRU(100, 40, time.Minute)
+	c.Set("a", entry(29))
+	c.Set("b", entry(29))
+	c.Set("c", entry(29))
+	assert.Equal(t, int64(90), c.Stats().Bytes)
+
+	// "a" becomes recently used, so "b" is evicted for "d"
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	c.Set("d", entry(29))
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok = c.Get(key)
+		assert.True(t, ok, key)
+	}
+
+	// replacing value changes used bytes
+	c.Set("a", entry(9))
+	assert.Equal(t, int64(70), c.Stats().Bytes)
+
+	// too big entry is rejected and old value of key is dropped
+	c.Set("a", entry(40))
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	st := c.Stats()
+	assert.Equal(t, uint64(1), st.Rejected)
+	assert.Equal(t, uint64(1), st.Evictions)
+	assert.Equal(t, 2, st.Size)
+	assert.Equal(t, int64(60), st.Bytes)
+	assert.Equal(t, int64(100), st.MaxBytes)
+
+	c.Purge()
+	assert.Equal(t, int64(0), c.Stats().Bytes)
+}
+
+func TestByteLRU_Expiration(t *testing.T) {
+	c := NewByteLRU(100, 0, time.Millisecond)
+	c.Set("a", Entry{Data: []byte("order")})
+	time.Sleep(5 * time.Millisecond)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	st := c.Stats()
+	assert.Equal(t, int64(0), st.Bytes)
+	assert.Equal(t, uint64(1), st.Evictions)
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, kind := range []string{TypeLRU, TypeSharded, TypeBytes} {
+		t.Run(kind, func(t *testing.T) {
+			cfg := Config{Type: kind, Size: 10, Shards: 2, TTL: time.Minute, MaxBytes: 1024}
+			src, err := New(cfg)
+			require.Equal(t, nil, err)
+			storedAt := time.Now().Add(-time.Second).Round(0)
+			for _, key := range []string{"a", "b", "c"} {
+				src.Set(key, Entry{Data: []byte("order " + key), StoredAt: storedAt})
+			}
+			path := filepath.Join(t.TempDir(), "cache.snapshot")
+			n, err := SaveSnapshot(path, src)
+			require.Equal(t, nil, err)
+			assert.Equal(t, 3, n)
+
+			dst, err := New(cfg)
+			require.Equal(t, nil, err)
+			keys, err := LoadSnapshot(path, dst)
+			require.Equal(t, nil, err)
+			assert.ElementsMatch(t, []string{"a", "b", "c"}, keys)
+			for _, key := range keys {
+				e, ok := dst.Peek(key)
+				require.True(t, ok)
+				assert.Equal(t, "order "+key, string(e.Data))
+				assert.True(t, storedAt.Equal(e.StoredAt))
+			}
+		})
+	}
+}
+
+func TestSnapshot_Ignored(t *testing.T) {
+	src := NewExpirableLRU(10, time.Minute)
+	src.Set("a", Entry{Data: []byte("order"), StoredAt: time.Now()})
+	var buf bytes.Buffer
+	_, err := WriteSnapshot(&buf, src)
+	require.Equal(t, nil, err)
+	valid := buf.Bytes()
+
+	corrupt := bytes.Clone(valid)
+	corrupt[len(corrupt)-1] ^= 0xff
+	outdated := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(outdated[4:], SnapshotVersion+1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "corrupt", data: corrupt, want: ErrSnapshotChecksum},
+		{name: "truncated", data: valid[:len(valid)-3], want: ErrSnapshotChecksum},
+		{name: "outdated", data: outdated, want: ErrSnapshotVersion},
+		{name: "not_snapshot", data: []byte("{\"order_uid\": \"a\"}\n...................................."), want: ErrSnapshotFormat},
+		{name: "empty", data: nil, want: ErrSnapshotFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := NewExpirableLRU(10, time.Minute)
+			keys, err := ReadSnapshot(bytes.NewReader(tt.data), dst)
+			assert.ErrorIs(t, err, tt.want)
+			assert.Empty(t, keys)
+			assert.Equal(t, 0, dst.Stats().Size)
+		})
+	}
+
+	_, err = LoadSnapshot(filepath.Join(t.TempDir(), "missing"), NewExpirableLRU(10, time.Minute))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestReconcile(t *testing.T) {
+	useCache(t, 0, 0, 1, nil)
+	load = func(ctx context.Context, id string) (Entry, *customerrors.CustomError) {
+		if id == "deleted" {
+			return Entry{}, &customerrors.CustomError{Message: "not found", Status: http.StatusNotFound}
+		}
+		if id == "db_error" {
+			return Entry{}, &customerrors.CustomError{Message: "db is down", Status: http.StatusInternalServerError}
+		}
+		LRUCache.Set(id, Entry{Data: []byte("new")})
+		return Entry{Data: []byte("new")}, nil
+	}
+	keys := []string{"changed", "deleted", "db_error"}
+	for _, key := range keys {
+		LRUCache.Set(key, Entry{Data: []byte("old")})
+	}
+	reconcile(context.Background(), zap.NewNop().Sugar(), keys)
+
+	e, ok := LRUCache.Peek("changed")
+	require.True(t, ok)
+	assert.Equal(t, "new", string(e.Data))
+	_, ok = LRUCache.Peek("deleted")
+	assert.False(t, ok)
+	e, ok = LRUCache.Peek("db_error")
+	require.True(t, ok)
+	assert.Equal(t, "old", string(e.Data))
+}
+
+func TestWarmup(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	useCache(t, 0, 0, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return []byte("order"), nil
+	})
+	ids := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	st := Warmup(context.Background(), ids, 4, time.Second)
+	assert.Equal(t, WarmupStats{Requested: 8, Loaded: 8}, WarmupStats{Requested: st.Requested, Loaded: st.Loaded, Failed: st.Failed, Skipped: st.Skipped})
+	assert.LessOrEqual(t, maxRunning.Load(), int32(4))
+	for _, id := range ids {
+		_, ok := LRUCache.Peek(id)
+		assert.True(t, ok, id)
+	}
+}
+
+func TestWarmup_Budget(t *testing.T) {
+	useCache(t, 0, 0, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		time.Sleep(50 * time.Millisecond)
+		return []byte("order"), nil
+	})
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	st := Warmup(context.Background(), ids, 2, 120*time.Millisecond)
+	assert.Less(t, st.Duration, time.Second)
+	assert.Equal(t, int64(100), st.Loaded+st.Failed+st.Skipped)
+	assert.Greater(t, st.Skipped, int64(80))
+	// loads cut by budget finish in background
+	require.Eventually(t, func() bool {
+		loads.mu.Lock()
+		defer loads.mu.Unlock()
+		return len(loads.calls) == 0
+	}, time.Second, time.Millisecond)
+	// the first ids are loaded first
+	_, ok := LRUCache.Peek("0")
+	assert.True(t, ok)
+}
+
+func TestGetOrder_NotFound(t *testing.T) {
+	var calls atomic.Int32
+	stored := false
+	useCache(t, 0, 0, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		calls.Add(1)
+		if !stored {
+			return nil, notFoundError("id")
+		}
+		return []byte("order"), nil
+	})
+	NotFound = NewExpirableLRU(10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, cErr := GetOrder(context.Background(), "id")
+		require.NotNil(t, cErr)
+		assert.Equal(t, http.StatusNotFound, int(cErr.Status))
+	}
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, uint64(2), negativeHits.Load())
+
+	// order is ingested
+	stored = true
+	Invalidate("id")
+	data, cErr := GetOrder(context.Background(), "id")
+	require.Nil(t, cErr)
+	assert.Equal(t, "order", string(data))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestGetOrder_Bloom(t *testing.T) {
+	var calls atomic.Int32
+	useCache(t, 0, 0, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		calls.Add(1)
+		return []byte("order"), nil
+	})
+	Known = NewBloom(100, 0.01)
+	Known.Add("known")
+
+	// filter which is not built yet doesn't reject anything
+	_, cErr := GetOrder(context.Background(), "unknown")
+	require.Nil(t, cErr)
+	assert.Equal(t, int32(1), calls.Load())
+
+	Known.SetReady()
+	LRUCache.Purge()
+	_, cErr = GetOrder(context.Background(), "unknown")
+	require.NotNil(t, cErr)
+	assert.Equal(t, http.StatusNotFound, int(cErr.Status))
+	_, cErr = GetOrder(context.Background(), "known")
+	require.Nil(t, cErr)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// ingested order is added to filter
+	Invalidate("unknown")
+	_, cErr = GetOrder(context.Background(), "unknown")
+	require.Nil(t, cErr)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestBloom(t *testing.T) {
+	b := NewBloom(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		b.Add(fmt.Sprintf("known-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, b.MayContain(fmt.Sprintf("known-%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.MayContain(fmt.Sprintf("unknown-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300)
+}
+
+func TestBloom_Rebuild(t *testing.T) {
+	b := NewBloom(1000, 0.01)
+	b.Add("deleted")
+	b.SetReady()
+	// invalidation of order stored by other replica was lost
+	assert.True(t, b.Rejects("lost"))
+
+	err := b.Rebuild(func(add func(key string)) error {
+		add("lost")
+		// order ingested while filter is rebuilt
+		b.Add("ingested")
+		return nil
+	})
+	require.Equal(t, nil, err)
+	assert.False(t, b.Rejects("lost"))
+	assert.False(t, b.Rejects("ingested"))
+	assert.True(t, b.Rejects("deleted"))
+	assert.Equal(t, uint64(2), b.inserted.Load())
+
+	err = b.Rebuild(func(add func(key string)) error {
+		return errors.New("Psql db is unavailable")
+	})
+	require.NotEqual(t, nil, err)
+	assert.False(t, b.Rejects("lost"))
+	b.Add("after")
+	assert.False(t, b.Rejects("after"))
+}
+
+func TestNewEntry(t *testing.T) {
+	prev := Encodings
+	defer func() { Encodings = prev }()
+	var err error
+	Encodings, err = ParseEncodings("br, zstd,gzip")
+	require.Equal(t, nil, err)
+	_, err = ParseEncodings("gzip,deflate")
+	assert.NotNil(t, err)
+
+	data := bytes.Repeat([]byte(`{"chrt_id": 9934930, "track_number": "WBILMTESTTRACK"}`), 20)
+	e := NewEntry(data)
+	require.Len(t, e.Encoded, 3)
+	gz, err := gzip.NewReader(bytes.NewReader(e.Encoded[EncodingGzip]))
+	require.Equal(t, nil, err)
+	got, err := io.ReadAll(gz)
+	require.Equal(t, nil, err)
+	assert.Equal(t, data, got)
+	got, err = io.ReadAll(brotli.NewReader(bytes.NewReader(e.Encoded[EncodingBrotli])))
+	require.Equal(t, nil, err)
+	assert.Equal(t, data, got)
+	dec, err := zstd.NewReader(nil)
+	require.Equal(t, nil, err)
+	got, err = dec.DecodeAll(e.Encoded[EncodingZstd], nil)
+	require.Equal(t, nil, err)
+	assert.Equal(t, data, got)
+
+	// variants of tiny order would be bigger than order itself
+	assert.Empty(t, NewEntry([]byte("{}")).Encoded)
+
+	// cache sized in bytes counts variants too
+	c := NewByteLRU(1<<20, 0, time.Minute)
+	c.Set("a", e)
+	assert.Equal(t, entrySize("a", e), c.Stats().Bytes)
+	assert.Greater(t, c.Stats().Bytes, int64(1+len(data)))
+}
+
+func TestNewEntry_ZstdFailed(t *testing.T) {
+	prev, prevNew := Encodings, newZstdEncoder
+	resetZstd := func() {
+		zstdEncoder.once = sync.Once{}
+		zstdEncoder.enc, zstdEncoder.err = nil, nil
+	}
+	defer func() {
+		Encodings, newZstdEncoder = prev, prevNew
+		resetZstd()
+	}()
+	resetZstd()
+	newZstdEncoder = func() (*zstd.Encoder, error) {
+		return nil, errors.New("no memory")
+	}
+	Encodings = []string{EncodingZstd, EncodingGzip}
+
+	_, err := encodeZstd([]byte("order"))
+	assert.ErrorContains(t, err, "no memory")
+	// order is cached without variant which couldn't be encoded
+	data := bytes.Repeat([]byte(`{"chrt_id": 9934930, "track_number": "WBILMTESTTRACK"}`), 20)
+	e := NewEntry(data)
+	assert.Equal(t, data, e.Data)
+	require.Len(t, e.Encoded, 1)
+	assert.NotEmpty(t, e.Encoded[EncodingGzip])
+}
+
+func TestCollector(t *testing.T) {
+	useCache(t, 0, 0, 1, func(ctx context.Context) ([]byte, *customerrors.CustomError) {
+		return []byte("order"), nil
+	})
+	ctx := context.Background()
+	for _, id := range []string{"a", "a", "b"} {
+		_, cErr := GetOrder(ctx, id)
+		require.Nil(t, cErr)
+	}
+	expected := `
+# HELP l0_cache_hits_total Lookups found in cache.
+# TYPE l0_cache_hits_total counter
+l0_cache_hits_total 1
+# HELP l0_cache_misses_total Lookups not found in cache.
+# TYPE l0_cache_misses_total counter
+l0_cache_misses_total 2
+# HELP l0_cache_size Entries in cache.
+# TYPE l0_cache_size gauge
+l0_cache_size 2
+# HELP l0_cache_loads_total Loads of orders from Psql db on miss.
+# TYPE l0_cache_loads_total counter
+l0_cache_loads_total 2
+`
+	err := testutil.CollectAndCompare(NewCollector(), strings.NewReader(expected),
+		"l0_cache_hits_total", "l0_cache_misses_total", "l0_cache_size", "l0_cache_loads_total")
+	require.Equal(t, nil, err)
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings which can be kept in cache next to json of order
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// Encodings are produced once for every entry, the first is preferred
+var Encodings = []string{EncodingGzip}
+
+// Encoders are slower and stronger than streaming compression of responses,
+// they run only once per loaded order
+var Encoders = map[string]func(data []byte) ([]byte, error){
+	EncodingGzip:   encodeGzip,
+	EncodingZstd:   encodeZstd,
+	EncodingBrotli: encodeBrotli,
+}
+
+// zstdEncoder is created on first use and shared, EncodeAll is safe for concurrent use
+var zstdEncoder struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	err  error
+}
+
+// newZstdEncoder is replaced in tests to fail
+var newZstdEncoder = func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+}
+
+func encodeGzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeZstd(data []byte) ([]byte, error) {
+	zstdEncoder.once.Do(func() {
+		zstdEncoder.enc, zstdEncoder.err = newZstdEncoder()
+	})
+	if zstdEncoder.err != nil {
+		return nil, fmt.Errorf("Problem with creation of zstd encoder: %w", zstdEncoder.err)
+	}
+	return zstdEncoder.enc.EncodeAll(data, nil), nil
+}
+
+func encodeBrotli(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, 9)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseEncodings reads comma separated list like 'br,gzip', empty list disables variants
+func ParseEncodings(list string) ([]string, error) {
+	encodings := make([]string, 0)
+	for _, enc := range strings.Split(list, ",") {
+		enc = strings.TrimSpace(enc)
+		if enc == "" {
+			continue
+		}
+		if _, ok := Encoders[enc]; !ok {
+			return nil, fmt.Errorf("Unknown cache encoding '%s'", enc)
+		}
+		encodings = append(encodings, enc)
+	}
+	return encodings, nil
+}
+
+// NewEntry compresses data by every configured encoding,
+// variant which is not smaller than data is not kept
+func NewEntry(data []byte) Entry {
+	e := Entry{Data: data, StoredAt: time.Now()}
+	for _, enc := range Encodings {
+		encoded, err := Encoders[enc](data)
+		if err != nil || len(encoded) >= len(data) {
+			continue
+		}
+		if e.Encoded == nil {
+			e.Encoded = make(map[string][]byte, len(Encodings))
+		}
+		e.Encoded[enc] = encoded
+	}
+	return e
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/tracing"
+)
+
+type loadFunc func(ctx context.Context) (Entry, *customerrors.CustomError)
+
+type call struct {
+	done  chan struct{}
+	entry Entry
+	cErr  *customerrors.CustomError
+	// waiters is number of callers waiting for the load, it is cancelled when all of them are gone
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flight runs one load per key at a time, concurrent misses of the same key
+// wait for the result of the running load instead of going to Psql db again
+type flight struct {
+	mu        sync.Mutex
+	calls     map[string]*call
+	loads     atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*call)}
+}
+
+// Do returns result of load for key. The load is detached from ctx of the caller
+// who started it, so cancellation of one caller doesn't fail others, only its span is kept,
+// every caller stops waiting when its own ctx is done and the load is cancelled
+// when no caller waits for it
+func (f *flight) Do(ctx context.Context, key string, load loadFunc) (Entry, *customerrors.CustomError) {
+	f.mu.Lock()
+	// load without waiters is cancelled already
+	c, ok := f.calls[key]
+	if ok && c.waiters > 0 {
+		f.coalesced.Add(1)
+	} else {
+		loadCtx, cancel := context.WithCancel(tracing.Detach(ctx))
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		f.calls[key] = c
+		f.loads.Add(1)
+		go f.run(loadCtx, key, c, load)
+	}
+	c.waiters++
+	f.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.entry, c.cErr
+	case <-ctx.Done():
+		f.leave(c)
+		return Entry{}, &customerrors.CustomError{
+			Message: ctx.Err().Error(),
+			Status:  http.StatusGatewayTimeout,
+		}
+	}
+}
+
+// leave cancels load nobody waits for, next caller of key starts a new one
+func (f *flight) leave(c *call) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.waiters--
+	if c.waiters == 0 {
+		c.cancel()
+	}
+}
+
+func (f *flight) run(ctx context.Context, key string, c *call, load loadFunc) {
+	defer func() {
+		f.mu.Lock()
+		if f.calls[key] == c {
+			delete(f.calls, key)
+		}
+		f.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	c.entry, c.cErr = load(ctx)
+}
+
+type FlightStats struct {
+	Loads          uint64 `json:"loads"`
+	CoalescedLoads uint64 `json:"coalesced_loads"`
+}
+
+func (f *flight) Stats() FlightStats {
+	return FlightStats{
+		Loads:          f.loads.Load(),
+		CoalescedLoads: f.coalesced.Load(),
+	}
+}
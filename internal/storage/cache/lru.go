@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// ExpirableLRU is a cache with single lock on top of hashicorp expirable LRU
+type ExpirableLRU struct {
+	lru      *expirable.LRU[string, Entry]
+	capacity int
+	ttl      time.Duration
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	// removed counts callbacks of explicit Delete and Purge, they are not evictions
+	removed atomic.Uint64
+	evicted atomic.Uint64
+}
+
+func NewExpirableLRU(size int, ttl time.Duration) *ExpirableLRU {
+	c := &ExpirableLRU{capacity: size, ttl: ttl}
+	c.lru = expirable.NewLRU[string, Entry](size, func(key string, value Entry) {
+		c.evicted.Add(1)
+	}, ttl)
+	return c
+}
+
+func (c *ExpirableLRU) Get(key string) (Entry, bool) {
+	v, ok := c.lru.Get(key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return v, ok
+}
+
+func (c *ExpirableLRU) Set(key string, value Entry) {
+	value.ExpiresAt = time.Time{}
+	if c.ttl > 0 {
+		value.ExpiresAt = time.Now().Add(c.ttl)
+	}
+	c.lru.Add(key, value)
+}
+
+func (c *ExpirableLRU) Delete(key string) bool {
+	ok := c.lru.Remove(key)
+	if ok {
+		c.removed.Add(1)
+	}
+	return ok
+}
+
+func (c *ExpirableLRU) Peek(key string) (Entry, bool) {
+	return c.lru.Peek(key)
+}
+
+func (c *ExpirableLRU) Keys() []string {
+	return c.lru.Keys()
+}
+
+func (c *ExpirableLRU) Purge() {
+	c.removed.Add(uint64(c.lru.Len()))
+	c.lru.Purge()
+}
+
+func (c *ExpirableLRU) Stats() Stats {
+	evictions := c.evicted.Load()
+	removed := c.removed.Load()
+	if removed > evictions {
+		evictions = removed
+	}
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: evictions - removed,
+		Size:      c.lru.Len(),
+		Capacity:  c.capacity,
+	}
+}
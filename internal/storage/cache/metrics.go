@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func cacheDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName("l0", "cache", name), help, nil, nil)
+}
+
+var (
+	hitsDesc        = cacheDesc("hits_total", "Lookups found in cache.")
+	missesDesc      = cacheDesc("misses_total", "Lookups not found in cache.")
+	evictionsDesc   = cacheDesc("evictions_total", "Entries evicted by capacity or time limit.")
+	rejectedDesc    = cacheDesc("rejected_total", "Entries too big to be cached.")
+	sizeDesc        = cacheDesc("size", "Entries in cache.")
+	capacityDesc    = cacheDesc("capacity", "Max entries in cache.")
+	bytesDesc       = cacheDesc("bytes", "Bytes of entries in cache.")
+	loadsDesc       = cacheDesc("loads_total", "Loads of orders from Psql db on miss.")
+	coalescedDesc   = cacheDesc("coalesced_loads_total", "Misses which waited for load of another request.")
+	staleDesc       = cacheDesc("stale_served_total", "Stale entries served while they were refreshed.")
+	refreshFailDesc = cacheDesc("refresh_failures_total", "Failed background refreshes.")
+	negativeDesc    = cacheDesc("negative_hits_total", "Lookups of unknown orders answered by not found cache.")
+	bloomDesc       = cacheDesc("bloom_rejected_total", "Lookups of unknown orders rejected by known ids filter.")
+)
+
+// collector reads counters of LRUCache on every scrape
+type collector struct{}
+
+func NewCollector() prometheus.Collector {
+	return collector{}
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		hitsDesc, missesDesc, evictionsDesc, rejectedDesc, sizeDesc, capacityDesc, bytesDesc,
+		loadsDesc, coalescedDesc, staleDesc, refreshFailDesc, negativeDesc, bloomDesc,
+	} {
+		ch <- d
+	}
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	r := GetReport()
+	counter := func(d *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v))
+	}
+	gauge := func(d *prometheus.Desc, v int64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, float64(v))
+	}
+	counter(hitsDesc, r.Hits)
+	counter(missesDesc, r.Misses)
+	counter(evictionsDesc, r.Evictions)
+	counter(rejectedDesc, r.Rejected)
+	gauge(sizeDesc, int64(r.Size))
+	gauge(capacityDesc, int64(r.Capacity))
+	gauge(bytesDesc, r.Bytes)
+	counter(loadsDesc, r.Loads)
+	counter(coalescedDesc, r.CoalescedLoads)
+	counter(staleDesc, r.StaleServed)
+	counter(refreshFailDesc, r.RefreshFailures)
+	counter(negativeDesc, r.NegativeHits)
+	counter(bloomDesc, r.BloomRejected)
+}
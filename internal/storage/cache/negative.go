@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"go.uber.org/zap"
+)
+
+// KnownPageSize is number of order uids read by one query while Known is built
+const KnownPageSize = 1000
+
+// NotFound keeps order uids which were not found in Psql db for a short time,
+// nil disables negative caching
+var NotFound Cache
+
+// Known has every stored order uid, nil disables it
+var Known *Bloom
+
+var negativeHits atomic.Uint64
+var bloomRejected atomic.Uint64
+
+type NegativeStats struct {
+	NegativeHits  uint64 `json:"negative_hits"`
+	NegativeSize  int    `json:"negative_size"`
+	BloomRejected uint64 `json:"bloom_rejected"`
+	BloomReady    bool   `json:"bloom_ready"`
+	BloomInserted uint64 `json:"bloom_inserted"`
+}
+
+func GetNegativeStats() NegativeStats {
+	st := NegativeStats{
+		NegativeHits:  negativeHits.Load(),
+		BloomRejected: bloomRejected.Load(),
+	}
+	if NotFound != nil {
+		st.NegativeSize = NotFound.Stats().Size
+	}
+	if Known != nil {
+		st.BloomReady = Known.ready.Load()
+		st.BloomInserted = Known.inserted.Load()
+	}
+	return st
+}
+
+func notFoundError(id string) *customerrors.CustomError {
+	return &customerrors.CustomError{
+		Message: fmt.Sprintf("Order '%s' was not found", id),
+		Status:  http.StatusNotFound,
+	}
+}
+
+// checkUnknown returns error when order is surely absent in Psql db
+func checkUnknown(id string) *customerrors.CustomError {
+	if NotFound != nil {
+		if _, ok := NotFound.Get(id); ok {
+			negativeHits.Add(1)
+			return notFoundError(id)
+		}
+	}
+	if Known != nil && Known.Rejects(id) {
+		bloomRejected.Add(1)
+		return notFoundError(id)
+	}
+	return nil
+}
+
+func rememberUnknown(id string, cErr *customerrors.CustomError) {
+	if NotFound != nil && cErr != nil && cErr.Status == http.StatusNotFound {
+		NotFound.Set(id, Entry{})
+	}
+}
+
+// buildKnown adds all stored order uids to b, orders stored meanwhile
+// are added by Invalidate, so b is ready right after the last page.
+// Invalidation is at most once, so b is rebuilt every interval to add uids it missed
+func buildKnown(ctx context.Context, log *zap.SugaredLogger, b *Bloom, interval time.Duration) {
+	err := listKnown(ctx, b.Add)
+	if err != nil {
+		log.Warnf("Problem with building of known orders filter, it is disabled: %s", err.Error())
+		return
+	}
+	b.SetReady()
+	log.Infof("Known orders filter is built with %d orders", b.inserted.Load())
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err = b.Rebuild(func(add func(key string)) error {
+			return listKnown(ctx, add)
+		})
+		if err != nil {
+			log.Warnf("Problem with rebuilding of known orders filter, previous one is kept: %s", err.Error())
+			continue
+		}
+		log.Debugf("Known orders filter is rebuilt with %d orders", b.inserted.Load())
+	}
+}
+
+// listKnown passes every stored order uid to add page by page
+func listKnown(ctx context.Context, add func(key string)) error {
+	after := ""
+	for {
+		ids, err := postgres.DBWorker.ListOrderIDs(ctx, nil, after, KnownPageSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			add(id)
+		}
+		if len(ids) < KnownPageSize {
+			return nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+)
+
+type freshness int
+
+const (
+	fresh freshness = iota
+	// stale entry is served while it is refreshed in background
+	stale
+	// expired entry is older than hard time limit and can't be served
+	expired
+)
+
+// refresher reloads stale entries in background,
+// number of refreshes at the same time is limited by size of sem
+type refresher struct {
+	soft    time.Duration
+	hard    time.Duration
+	sem     chan struct{}
+	running sync.Map
+
+	staleServed atomic.Uint64
+	refreshes   atomic.Uint64
+	failures    atomic.Uint64
+	skipped     atomic.Uint64
+}
+
+// newRefresher with zero soft time limit treats every entry younger than hard one as fresh
+func newRefresher(soft, hard time.Duration, concurrency int) *refresher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &refresher{soft: soft, hard: hard, sem: make(chan struct{}, concurrency)}
+}
+
+// state checks hard time limit even without soft one, cache store keeps values longer
+func (r *refresher) state(e Entry) freshness {
+	age := time.Since(e.StoredAt)
+	if r.hard > 0 && age >= r.hard {
+		return expired
+	}
+	if r.soft <= 0 || age < r.soft {
+		return fresh
+	}
+	return stale
+}
+
+// start runs refresh of id unless it is already running or all slots are busy,
+// stale value stays in cache until refresh succeeds or hard time limit is reached
+func (r *refresher) start(id string) {
+	if _, busy := r.running.LoadOrStore(id, struct{}{}); busy {
+		return
+	}
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		r.running.Delete(id)
+		r.skipped.Add(1)
+		return
+	}
+	go func() {
+		defer func() {
+			<-r.sem
+			r.running.Delete(id)
+		}()
+		_, cErr := loads.Do(context.Background(), id, func(ctx context.Context) (Entry, *customerrors.CustomError) {
+			return load(ctx, id)
+		})
+		if cErr != nil {
+			r.failures.Add(1)
+			if cErr.Status == http.StatusNotFound {
+				LRUCache.Delete(id)
+			}
+			return
+		}
+		r.refreshes.Add(1)
+	}()
+}
+
+type RefreshStats struct {
+	StaleServed      uint64 `json:"stale_served"`
+	Refreshes        uint64 `json:"refreshes"`
+	RefreshFailures  uint64 `json:"refresh_failures"`
+	RefreshesSkipped uint64 `json:"refreshes_skipped"`
+}
+
+func (r *refresher) Stats() RefreshStats {
+	return RefreshStats{
+		StaleServed:      r.staleServed.Load(),
+		Refreshes:        r.refreshes.Load(),
+		RefreshFailures:  r.failures.Load(),
+		RefreshesSkipped: r.skipped.Load(),
+	}
+}
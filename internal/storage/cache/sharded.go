@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// Sharded spreads keys across independent LRUs, so concurrent requests
+// of different orders don't wait for the same lock
+type Sharded struct {
+	shards []*ExpirableLRU
+}
+
+// NewSharded splits size between shards, every shard keeps one entry at least
+func NewSharded(shards int, size int, ttl time.Duration) *Sharded {
+	if shards < 1 {
+		shards = 1
+	}
+	perShard := (size + shards - 1) / shards
+	if perShard < 1 {
+		perShard = 1
+	}
+	c := &Sharded{shards: make([]*ExpirableLRU, shards)}
+	for i := range c.shards {
+		c.shards[i] = NewExpirableLRU(perShard, ttl)
+	}
+	return c
+}
+
+func (c *Sharded) shard(key string) *ExpirableLRU {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+func (c *Sharded) Get(key string) (Entry, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *Sharded) Set(key string, value Entry) {
+	c.shard(key).Set(key, value)
+}
+
+func (c *Sharded) Delete(key string) bool {
+	return c.shard(key).Delete(key)
+}
+
+func (c *Sharded) Peek(key string) (Entry, bool) {
+	return c.shard(key).Peek(key)
+}
+
+// Keys keeps order only inside of every shard
+func (c *Sharded) Keys() []string {
+	var keys []string
+	for _, s := range c.shards {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+func (c *Sharded) Purge() {
+	for _, s := range c.shards {
+		s.Purge()
+	}
+}
+
+func (c *Sharded) Stats() Stats {
+	var total Stats
+	for _, s := range c.shards {
+		total = total.Add(s.Stats())
+	}
+	return total
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotVersion is increased on every change of snapshot format,
+// snapshots of other versions are ignored
+const SnapshotVersion uint16 = 1
+
+var snapshotMagic = [4]byte{'L', '0', 'C', 'S'}
+
+var (
+	ErrSnapshotFormat   = errors.New("file is not a cache snapshot")
+	ErrSnapshotVersion  = errors.New("cache snapshot version is not supported")
+	ErrSnapshotChecksum = errors.New("cache snapshot checksum mismatch")
+)
+
+// snapshotHeader is followed by gob encoded snapshot of PayloadSize bytes
+type snapshotHeader struct {
+	Magic       [4]byte
+	Version     uint16
+	Checksum    [sha256.Size]byte
+	PayloadSize uint64
+}
+
+type snapshot struct {
+	CreatedAt time.Time
+	Entries   []snapshotEntry
+}
+
+type snapshotEntry struct {
+	Key      string
+	Data     []byte
+	StoredAt time.Time
+}
+
+// WriteSnapshot writes entries of c from the least recently used
+func WriteSnapshot(w io.Writer, c Cache) (int, error) {
+	snap := snapshot{CreatedAt: time.Now()}
+	for _, key := range c.Keys() {
+		e, ok := c.Peek(key)
+		if !ok {
+			continue
+		}
+		snap.Entries = append(snap.Entries, snapshotEntry{Key: key, Data: e.Data, StoredAt: e.StoredAt})
+	}
+	var payload bytes.Buffer
+	err := gob.NewEncoder(&payload).Encode(snap)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with encoding of cache snapshot: %w", err)
+	}
+	header := snapshotHeader{
+		Magic:       snapshotMagic,
+		Version:     SnapshotVersion,
+		Checksum:    sha256.Sum256(payload.Bytes()),
+		PayloadSize: uint64(payload.Len()),
+	}
+	err = binary.Write(w, binary.BigEndian, header)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with writing of cache snapshot: %w", err)
+	}
+	_, err = w.Write(payload.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("Problem with writing of cache snapshot: %w", err)
+	}
+	return len(snap.Entries), nil
+}
+
+// ReadSnapshot puts entries to c only when whole snapshot is valid
+// and returns their keys
+func ReadSnapshot(r io.Reader, c Cache) ([]string, error) {
+	var header snapshotHeader
+	err := binary.Read(r, binary.BigEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotFormat, err.Error())
+	}
+	if header.Magic != snapshotMagic {
+		return nil, ErrSnapshotFormat
+	}
+	if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, header.Version)
+	}
+	payload, err := io.ReadAll(io.LimitReader(r, int64(header.PayloadSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading of cache snapshot: %w", err)
+	}
+	if uint64(len(payload)) != header.PayloadSize || sha256.Sum256(payload) != header.Checksum {
+		return nil, ErrSnapshotChecksum
+	}
+	var snap snapshot
+	err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&snap)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotFormat, err.Error())
+	}
+	keys := make([]string, 0, len(snap.Entries))
+	for _, e := range snap.Entries {
+		entry := NewEntry(e.Data)
+		entry.StoredAt = e.StoredAt
+		c.Set(e.Key, entry)
+		keys = append(keys, e.Key)
+	}
+	return keys, nil
+}
+
+// SaveSnapshot replaces file at path only after snapshot is fully written
+func SaveSnapshot(path string, c Cache) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("Problem with creation of cache snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	n, err := WriteSnapshot(w, c)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return 0, err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with saving of cache snapshot: %w", err)
+	}
+	return n, nil
+}
+
+func LoadSnapshot(path string, c Cache) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSnapshot(bufio.NewReader(f), c)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+)
+
+type WarmupStats struct {
+	Requested int           `json:"requested"`
+	Loaded    int64         `json:"loaded"`
+	Failed    int64         `json:"failed"`
+	Skipped   int64         `json:"skipped"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Warmup loads orders by several workers, ids are taken in given order
+// so the most wanted ones are loaded first. Orders not loaded within budget are skipped
+func Warmup(ctx context.Context, ids []string, concurrency int, budget time.Duration) WarmupStats {
+	start := time.Now()
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	var loaded, failed atomic.Int64
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				id := id
+				_, cErr := loads.Do(ctx, id, func(ctx context.Context) (Entry, *customerrors.CustomError) {
+					return load(ctx, id)
+				})
+				if cErr != nil {
+					failed.Add(1)
+					continue
+				}
+				loaded.Add(1)
+			}
+		}()
+	}
+	sent := 0
+loop:
+	for _, id := range ids {
+		select {
+		case queue <- id:
+			sent++
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return WarmupStats{
+		Requested: len(ids),
+		Loaded:    loaded.Load(),
+		Failed:    failed.Load(),
+		Skipped:   int64(len(ids) - sent),
+		Duration:  time.Since(start),
+	}
+}
@@ -0,0 +1,29 @@
+package history
+
+import "time"
+
+type Record struct {
+	OrderID     string    `json:"order_id"`
+	TriggeredAt time.Time `json:"triggered_at"`
+}
+
+// Warmup strategies choose orders loaded to cache on start
+const (
+	// WarmupRecent takes the last requested orders
+	WarmupRecent = "recent"
+	// WarmupFrequent takes orders with the most hits in window
+	WarmupFrequent = "frequent"
+	// WarmupHybrid takes orders with the most hits in window,
+	// every hit weighs less the older its bucket is
+	WarmupHybrid = "hybrid"
+)
+
+var WarmupStrategies = []string{WarmupRecent, WarmupFrequent, WarmupHybrid}
+
+// AccessBucket is a number of requests of order during one hour starting at Start
+type AccessBucket struct {
+	Start time.Time `json:"start"`
+	Hits  int64     `json:"hits"`
+}
+
+const AccessBucketSize = time.Hour
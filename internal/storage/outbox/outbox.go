@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// StoredSubjectPrefix is followed by order uid in subject of 'order.stored' event
+const StoredSubjectPrefix = "orders.stored."
+
+// Message is a row of NATS outbox written in the same tx as order
+type Message struct {
+	ID        int64
+	Subject   string
+	OrderID   string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// Lag describes messages which are not relayed to NATS yet
+type Lag struct {
+	Pending          int64   `json:"pending"`
+	OldestPendingSec float64 `json:"oldest_pending_secs"`
+}
+
+// StoredEvent is published to NATS when order is durably stored
+type StoredEvent struct {
+	Type     string          `json:"type"`
+	OrderID  string          `json:"order_uid"`
+	StoredAt time.Time       `json:"stored_at"`
+	Data     json.RawMessage `json:"data"`
+}
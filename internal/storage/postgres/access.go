@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/akashipov/L0project/internal/storage/history"
+)
+
+// AddOrderAccess counts request of order in bucket of hour of t
+func (w *SqlWorker) AddOrderAccess(ctx context.Context, tx *sql.Tx, orderID string, t int64) error {
+	ctx, span := startSpan(ctx, "AddOrderAccess")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	var err error
+	query := "INSERT INTO order_access(order_id, bucket, hits) VALUES($1, DATE_TRUNC('hour', TO_TIMESTAMP($2)), 1) " +
+		"ON CONFLICT (order_id, bucket) DO UPDATE SET hits = order_access.hits + 1"
+	if tx == nil {
+		_, err = w.DB.ExecContext(
+			ctx, query, orderID, t,
+		)
+	} else {
+		_, err = tx.ExecContext(
+			ctx, query, orderID, t,
+		)
+	}
+	if err != nil {
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
+		return fmt.Errorf("Problem with execution of Add Order Access query: %w", errors.Join(err, rollErr))
+	}
+	return nil
+}
+
+func (w *SqlWorker) DeleteOrderAccess(ctx context.Context, tx *sql.Tx, orderID string) error {
+	ctx, span := startSpan(ctx, "DeleteOrderAccess")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	var err error
+	query := "DELETE FROM order_access WHERE order_id = $1"
+	if tx == nil {
+		_, err = w.DB.ExecContext(
+			ctx, query, orderID,
+		)
+	} else {
+		_, err = tx.ExecContext(
+			ctx, query, orderID,
+		)
+	}
+	if err != nil {
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
+		return fmt.Errorf("Problem with execution of Delete Order Access query: %w", errors.Join(err, rollErr))
+	}
+	return nil
+}
+
+// DeleteOrderAccessBefore drops buckets which are out of every warmup window
+func (w *SqlWorker) DeleteOrderAccessBefore(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := startSpan(ctx, "DeleteOrderAccessBefore")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("DeleteOrderAccessBefore", time.Now())
+	res, err := w.DB.ExecContext(ctx, "DELETE FROM order_access WHERE bucket < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with execution of Delete Old Order Access query: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetOrderAccess returns hits of order by hours since the given time
+func (w *SqlWorker) GetOrderAccess(ctx context.Context, orderID string, since time.Time) ([]history.AccessBucket, error) {
+	ctx, span := startSpan(ctx, "GetOrderAccess")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetOrderAccess", time.Now())
+	rows, err := w.DB.QueryContext(
+		ctx,
+		"SELECT bucket, hits FROM order_access WHERE order_id = $1 AND bucket >= $2 ORDER BY bucket",
+		orderID, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Get Order Access query: %w", err)
+	}
+	defer rows.Close()
+	buckets := make([]history.AccessBucket, 0)
+	for rows.Next() {
+		var b history.AccessBucket
+		err = rows.Scan(&b.Start, &b.Hits)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan order access: %w", err)
+		}
+		buckets = append(buckets, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading order access rows: %w", err)
+	}
+	return buckets, nil
+}
+
+// GetWarmupIDs returns up to limit order uids chosen by strategy,
+// hits older than window are not taken into account
+func (w *SqlWorker) GetWarmupIDs(ctx context.Context, strategy string, window time.Duration, limit int) ([]string, error) {
+	ctx, span := startSpan(ctx, "GetWarmupIDs")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetWarmupIDs", time.Now())
+	var query string
+	args := []any{limit}
+	switch strategy {
+	case history.WarmupRecent:
+		query = "SELECT order_id FROM history ORDER BY triggered_at DESC LIMIT $1"
+	case history.WarmupFrequent:
+		query = "SELECT order_id FROM order_access WHERE bucket >= $2 " +
+			"GROUP BY order_id ORDER BY SUM(hits) DESC, MAX(bucket) DESC LIMIT $1"
+		args = append(args, time.Now().Add(-window))
+	case history.WarmupHybrid:
+		// hit of the last hour weighs 1, hit of day ago weighs 1/25
+		query = "SELECT order_id FROM order_access WHERE bucket >= $2 GROUP BY order_id " +
+			"ORDER BY SUM(hits / (1 + EXTRACT(EPOCH FROM NOW() - bucket) / 3600)) DESC LIMIT $1"
+		args = append(args, time.Now().Add(-window))
+	default:
+		return nil, fmt.Errorf("Unknown warmup strategy '%s'", strategy)
+	}
+	rows, err := w.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Get Warmup IDs query: %w", err)
+	}
+	defer rows.Close()
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan warmup id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading warmup ids: %w", err)
+	}
+	return ids, nil
+}
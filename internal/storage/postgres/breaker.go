@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/lib/pq"
+)
+
+// ErrUnavailable marks failures of Psql db itself, such calls are worth retrying later
+var ErrUnavailable = errors.New("Psql db is unavailable")
+
+// unavailable tells failures of connection, server and QueryTimeout from failures of one call.
+// ctx is the one of caller: when it is done, deadline of http request or nats message
+// has expired or caller is gone, that is not failure of Psql db
+func unavailable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	// ctx of caller is alive, so only QueryTimeout could expire
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection exception, insufficient resources, operator intervention
+		case "08", "53", "57":
+			return true
+		}
+	}
+	return false
+}
+
+// guard runs fn through Breaker, fn fails fast with ErrUnavailable while breaker is open
+func (w *SqlWorker) guard(ctx context.Context, fn func() error) error {
+	done, err := w.Breaker.Allow()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	err = fn()
+	failed := unavailable(ctx, err)
+	done(failed)
+	if failed {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// guardCustom is guard of calls which answer with http status, 503 and 504 are failures of Psql db
+// unless deadline of caller has expired or caller is gone
+func (w *SqlWorker) guardCustom(ctx context.Context, fn func() *customerrors.CustomError) *customerrors.CustomError {
+	done, err := w.Breaker.Allow()
+	if err != nil {
+		return &customerrors.CustomError{
+			Message: fmt.Errorf("%w: %w", ErrUnavailable, err).Error(),
+			Status:  http.StatusServiceUnavailable,
+		}
+	}
+	cErr := fn()
+	done(cErr != nil && ctx.Err() == nil &&
+		(cErr.Status == http.StatusServiceUnavailable || cErr.Status == http.StatusGatewayTimeout))
+	return cErr
+}
+
+// Unavailable is true while calls to Psql db are stopped by breaker
+func (w *SqlWorker) Unavailable() bool {
+	return w.Breaker.RetryAfter() > 0
+}
@@ -2,7 +2,9 @@ package postgres
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,14 +17,21 @@ import (
 	"time"
 
 	"github.com/akashipov/L0project/internal/arguments"
+	"github.com/akashipov/L0project/internal/breaker"
 	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/metrics"
 	"github.com/akashipov/L0project/internal/pkg/middleware/logger"
+	"github.com/akashipov/L0project/internal/storage/history"
 	"github.com/akashipov/L0project/internal/storage/item"
 	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/outbox"
 	"github.com/akashipov/L0project/internal/storage/payment"
 	"github.com/akashipov/L0project/internal/storage/user"
-	_ "github.com/lib/pq"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/akashipov/L0project/internal/tracing"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -40,27 +49,40 @@ func Start(ctx context.Context, t *testing.T) {
 		var err error
 		Log, err = logger.GetLogger()
 		require.Equal(t, nil, err)
-		_, err = NewSqlWorker()
+		_, err = NewSqlWorker(Log)
 		require.Equal(t, nil, err)
 	})
 }
 
 type SqlWorker struct {
 	DB *sql.DB
+	// OnChange is called with order uid after every committed change of order,
+	// cached copies of it are stale from that moment
+	OnChange func(orderID string)
+	Log      *zap.SugaredLogger
+	// QueryTimeout bounds every query, 0 leaves only deadline of caller
+	QueryTimeout time.Duration
+	// Breaker stops AddData, GetDataByID and AddOrderHistory while Psql db is unavailable
+	Breaker *breaker.Breaker
+	writes  writes
 }
 
 var DBWorker SqlWorker
 
-func NewSqlWorker() (*SqlWorker, error) {
+func NewSqlWorker(log *zap.SugaredLogger) (*SqlWorker, error) {
 	DB, err := InitDB()
 	if err != nil {
 		return nil, fmt.Errorf("Problem with init DB -> %w", err)
 	}
-	DBWorker = SqlWorker{DB: DB}
+	DBWorker = SqlWorker{DB: DB, Log: log}
 	return &DBWorker, nil
 }
 
 func (w *SqlWorker) AddOrder(ctx context.Context, tx *sql.Tx, ord order.Order) error {
+	ctx, span := startSpan(ctx, "AddOrder")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "INSERT INTO orders(order_id, track_number, entry, delivery_user, " +
 		"transaction_id, locale, internal_signature, customer_id, delivery_service, shardkey," +
@@ -89,13 +111,27 @@ func (w *SqlWorker) AddOrder(ctx context.Context, tx *sql.Tx, ord order.Order) e
 
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Add Order query: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
 func (w *SqlWorker) AddOrderHistory(ctx context.Context, tx *sql.Tx, order_id string, t int64) error {
+	ctx, span := startSpan(ctx, "AddOrderHistory")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("AddOrderHistory", time.Now())
+	return w.guard(ctx, func() error {
+		return w.addOrderHistory(ctx, tx, order_id, t)
+	})
+}
+
+func (w *SqlWorker) addOrderHistory(ctx context.Context, tx *sql.Tx, order_id string, t int64) error {
 	var err error
 	query := "INSERT INTO history(order_id, triggered_at) VALUES($1, TO_TIMESTAMP($2)) ON CONFLICT (order_id) DO UPDATE SET triggered_at = TO_TIMESTAMP($2)"
 	if tx == nil {
@@ -109,13 +145,20 @@ func (w *SqlWorker) AddOrderHistory(ctx context.Context, tx *sql.Tx, order_id st
 
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Add History Order query: %w", errors.Join(err, rollErr))
 	}
-	return nil
+	return w.AddOrderAccess(ctx, tx, order_id, t)
 }
 
 func (w *SqlWorker) DeleteOrderHistory(ctx context.Context, tx *sql.Tx, order_id string) error {
+	ctx, span := startSpan(ctx, "DeleteOrderHistory")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "DELETE FROM history WHERE order_id = $1"
 	if tx == nil {
@@ -128,35 +171,57 @@ func (w *SqlWorker) DeleteOrderHistory(ctx context.Context, tx *sql.Tx, order_id
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Add History Order query: %w", errors.Join(err, rollErr))
 	}
-	return nil
+	return w.DeleteOrderAccess(ctx, tx, order_id)
 }
 
-func (w *SqlWorker) AddUser(ctx context.Context, tx *sql.Tx, user user.User) error {
-	var err error
-	query := "INSERT INTO users(phonenumber, name, email, address_id) VALUES($1, $2, $3, $4) ON CONFLICT (phonenumber) DO UPDATE SET phonenumber = $1, name = $2, email = $3, address_id=$4"
+// AddUser returns true when stored user is changed, delivery of its orders is changed too
+func (w *SqlWorker) AddUser(ctx context.Context, tx *sql.Tx, user user.User) (bool, error) {
+	ctx, span := startSpan(ctx, "AddUser")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	query := "INSERT INTO users(phonenumber, name, email, address_id) VALUES($1, $2, $3, $4) ON CONFLICT (phonenumber) DO UPDATE " +
+		"SET name = $2, email = $3, address_id = $4 " +
+		"WHERE (users.name, users.email, users.address_id) IS DISTINCT FROM ($2, $3, $4) RETURNING xmax = 0"
+	var r *sql.Row
 	if tx == nil {
-		_, err = w.DB.ExecContext(
+		r = w.DB.QueryRowContext(
 			ctx, query, user.Phonenumber,
 			user.Name, user.Email, user.AddressID,
 		)
 	} else {
-		_, err = tx.ExecContext(
+		r = tx.QueryRowContext(
 			ctx, query, user.Phonenumber,
 			user.Name, user.Email, user.AddressID,
 		)
-
+	}
+	var inserted bool
+	err := r.Scan(&inserted)
+	// no row is returned for stored user without changes
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
-		return fmt.Errorf("Problem with execution of Add User query: %w", errors.Join(err, rollErr))
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
+		return false, fmt.Errorf("Problem with execution of Add User query: %w", errors.Join(err, rollErr))
 	}
-	return nil
+	return !inserted, nil
 }
 
 func (w *SqlWorker) AddAddress(ctx context.Context, tx *sql.Tx, add *user.Address) (int64, error) {
+	ctx, span := startSpan(ctx, "AddAddress")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	filename := "add_address.sql"
 	path := filepath.Join(
 		"statics",
@@ -179,13 +244,19 @@ func (w *SqlWorker) AddAddress(ctx context.Context, tx *sql.Tx, add *user.Addres
 	var i int64
 	err = r.Scan(&i)
 	if err != nil {
-		errRoll := tx.Rollback()
+		var errRoll error
+		if tx != nil {
+			errRoll = tx.Rollback()
+		}
 		return -1, fmt.Errorf("Problem with getting id of insert row: %w", errors.Join(err, errRoll))
 	}
 	return i, nil
 }
 
+// AddItems has no deadline of its own, every AddItem query gets QueryTimeout
 func (w *SqlWorker) AddItems(ctx context.Context, tx *sql.Tx, items []item.Item) error {
+	ctx, span := startSpan(ctx, "AddItems")
+	defer span.End()
 	for _, item := range items {
 		err := w.AddItem(ctx, tx, &item)
 		if err != nil {
@@ -196,55 +267,307 @@ func (w *SqlWorker) AddItems(ctx context.Context, tx *sql.Tx, items []item.Item)
 }
 
 func (w *SqlWorker) AddData(ctx context.Context, data []byte) error {
+	ctx, span := startSpan(ctx, "AddData")
+	defer span.End()
+	defer metrics.ObservePostgres("AddData", time.Now())
+	w.writes.begin()
+	defer w.writes.end()
+	err := w.guard(ctx, func() error {
+		return w.addData(ctx, data)
+	})
+	tracing.Fail(span, err)
+	return err
+}
+
+// ErrAlreadyStored is returned by AddData for order which is in Psql db already,
+// it is a message delivered again and nothing is changed
+var ErrAlreadyStored = errors.New("Order is already stored")
+
+// ErrInvalidOrder marks messages which are never stored: malformed json, missing parts
+// or values violating constraints of Psql db
+var ErrInvalidOrder = errors.New("Order is invalid")
+
+func decodeOrder(data []byte) (order.Order, error) {
 	var ord order.Order
-	tx, err := w.CreateTx()
+	err := json.Unmarshal(data, &ord)
 	if err != nil {
-		tx = nil
-		return err
+		return ord, fmt.Errorf("%w: %w", ErrInvalidOrder, err)
 	}
-	err = json.Unmarshal(data, &ord)
+	switch {
+	case ord.OrderID == "":
+		return ord, fmt.Errorf("%w: order_uid is empty", ErrInvalidOrder)
+	case ord.User == nil:
+		return ord, fmt.Errorf("%w: delivery is missing", ErrInvalidOrder)
+	case ord.PaymentInfo == nil:
+		return ord, fmt.Errorf("%w: payment is missing", ErrInvalidOrder)
+	}
+	return ord, nil
+}
+
+func (w *SqlWorker) addData(ctx context.Context, data []byte) error {
+	ord, err := decodeOrder(data)
 	if err != nil {
 		return err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.OrderID(ord.OrderID))
+	updated, err := w.insertData(ctx, &ord)
+	if err != nil {
+		return w.insertFailed(ctx, ord.OrderID, err)
+	}
+	w.changed(ord.OrderID)
+	for _, id := range updated {
+		w.changed(id)
+	}
+	w.log().Infow("Order was added", "order_id", ord.OrderID, "updated_orders", len(updated))
+	return nil
+}
+
+// insertData saves new order or overwrites stored one when content of it is changed.
+// It returns uids of other orders whose delivery is changed by user of order
+func (w *SqlWorker) insertData(ctx context.Context, ord *order.Order) ([]string, error) {
+	digest, err := orderDigest(ord)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := w.CreateTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	prev, err := w.storedOrder(ctx, tx, ord.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	eventType := webhook.EventOrderStored
+	if prev.found {
+		if prev.digest == digest {
+			return nil, ErrAlreadyStored
+		}
+		err = w.removeOrder(ctx, tx, ord.OrderID, prev.transactionID)
+		if err != nil {
+			return nil, err
+		}
+		eventType = webhook.EventOrderUpdated
+	}
 	addressID, err := w.AddAddress(ctx, tx, &ord.User.Address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	ord.User.AddressID = addressID
-	err = w.AddUser(ctx, tx, *ord.User)
+	userChanged, err := w.AddUser(ctx, tx, *ord.User)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var updated []string
+	// order itself is not stored yet, so it is not among them
+	if userChanged {
+		updated, err = w.addUserUpdatedEvents(ctx, tx, ord.User)
+		if err != nil {
+			return nil, err
+		}
 	}
 	err = w.AddPaymentInfo(ctx, tx, ord.PaymentInfo)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = w.AddOrder(ctx, tx, ord)
+	err = w.AddOrder(ctx, tx, *ord)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = w.saveOrderDigest(ctx, tx, ord.OrderID, digest)
+	if err != nil {
+		return nil, err
 	}
 	for idx := range ord.Items {
 		ord.Items[idx].OrderID = ord.OrderID
 	}
 	err = w.AddItems(ctx, tx, ord.Items)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(ord)
+	if err != nil {
+		return nil, err
+	}
+	err = w.AddOutboxEvent(ctx, tx, eventType, ord.OrderID, payload)
+	if err != nil {
+		return nil, err
+	}
+	// stream of stored orders gets order once
+	if !prev.found {
+		err = w.AddNatsOutboxMessage(ctx, tx, outbox.StoredSubjectPrefix+ord.OrderID, ord.OrderID, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updated, tx.Commit()
+}
+
+// orderDigest tells message delivered again from order sent with other content
+func orderDigest(ord *order.Order) (string, error) {
+	data, err := json.Marshal(ord)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// stored is order found in Psql db before it is saved
+type stored struct {
+	found  bool
+	digest string
+	// transactionID is payment of order, it is removed with order
+	transactionID string
+}
+
+func (w *SqlWorker) storedOrder(ctx context.Context, tx *sql.Tx, orderID string) (stored, error) {
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	query := "SELECT payload_sha256, COALESCE(transaction_id, '') FROM orders WHERE order_id = $1"
+	var r *sql.Row
+	if tx == nil {
+		r = w.DB.QueryRowContext(ctx, query, orderID)
+	} else {
+		r = tx.QueryRowContext(ctx, query, orderID)
+	}
+	var s stored
+	err := r.Scan(&s.digest, &s.transactionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stored{}, nil
+	}
+	if err != nil {
+		return stored{}, fmt.Errorf("Problem with checking of order '%s': %w", orderID, err)
+	}
+	s.found = true
+	return s, nil
+}
+
+func (w *SqlWorker) orderExists(ctx context.Context, tx *sql.Tx, orderID string) (bool, error) {
+	s, err := w.storedOrder(ctx, tx, orderID)
+	return s.found, err
+}
+
+func (w *SqlWorker) saveOrderDigest(ctx context.Context, tx *sql.Tx, orderID string, digest string) error {
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET payload_sha256 = $2 WHERE order_id = $1", orderID, digest)
+	if err != nil {
+		return fmt.Errorf("Problem with saving of order digest: %w", err)
+	}
+	return nil
+}
+
+// removeOrder deletes stored order, so it is saved again with new content in the same tx
+func (w *SqlWorker) removeOrder(ctx context.Context, tx *sql.Tx, orderID string, transactionID string) error {
+	err := w.DeleteItemsByOrderID(ctx, tx, orderID)
 	if err != nil {
 		return err
 	}
-	err = tx.Commit()
+	err = w.DeleteOrderByID(ctx, tx, orderID)
 	if err != nil {
 		return err
 	}
-	tx = nil
-	fmt.Printf("Order with '%s' was added successfully\n", ord.OrderID)
-	return nil
+	if transactionID == "" {
+		return nil
+	}
+	return w.DeletePaymentByID(ctx, tx, transactionID)
+}
+
+// addUserUpdatedEvents saves 'order.updated' events with new delivery for stored orders of user
+func (w *SqlWorker) addUserUpdatedEvents(ctx context.Context, tx *sql.Tx, usr *user.User) ([]string, error) {
+	ids, err := w.getOrderIDsByUser(ctx, tx, usr.Phonenumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		payload, err := json.Marshal(struct {
+			OrderID string     `json:"order_uid"`
+			User    *user.User `json:"delivery"`
+		}{OrderID: id, User: usr})
+		if err != nil {
+			return nil, err
+		}
+		err = w.AddOutboxEvent(ctx, tx, webhook.EventOrderUpdated, id, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (w *SqlWorker) getOrderIDsByUser(ctx context.Context, tx *sql.Tx, phone string) ([]string, error) {
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	rows, err := tx.QueryContext(ctx, "SELECT order_id FROM orders WHERE delivery_user = $1 ORDER BY order_id", phone)
+	if err != nil {
+		return nil, fmt.Errorf("Problem with getting orders of user: %w", err)
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with scanning of order id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// insertFailed tells order committed meanwhile by other delivery of the same message
+// from order violating constraints, other errors are kept as is
+func (w *SqlWorker) insertFailed(ctx context.Context, orderID string, err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code.Class() {
+	// integrity constraint violation
+	case "23":
+		if pqErr.Code == "23505" {
+			exists, existsErr := w.orderExists(ctx, nil, orderID)
+			if existsErr != nil {
+				return errors.Join(err, existsErr)
+			}
+			if exists {
+				return fmt.Errorf("%w: %w", ErrAlreadyStored, err)
+			}
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidOrder, err)
+	// data exception
+	case "22":
+		return fmt.Errorf("%w: %w", ErrInvalidOrder, err)
+	}
+	return err
+}
+
+func (w *SqlWorker) changed(orderID string) {
+	if w.OnChange != nil {
+		w.OnChange(orderID)
+	}
+}
+
+func (w *SqlWorker) log() *zap.SugaredLogger {
+	if w.Log == nil {
+		return zap.NewNop().Sugar()
+	}
+	return w.Log
 }
 
 func (w *SqlWorker) DeleteDataByOrderID(ctx context.Context, data []byte) error {
+	ctx, span := startSpan(ctx, "DeleteDataByOrderID")
+	defer span.End()
+	defer metrics.ObservePostgres("DeleteDataByOrderID", time.Now())
+	w.writes.begin()
+	defer w.writes.end()
 	var ord order.Order
-	tx, err := w.CreateTx()
+	tx, err := w.CreateTx(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 	err = json.Unmarshal(data, &ord)
 	if err != nil {
 		return err
@@ -261,15 +584,27 @@ func (w *SqlWorker) DeleteDataByOrderID(ctx context.Context, data []byte) error
 	if err != nil {
 		return err
 	}
+	payload, err := json.Marshal(ord)
+	if err != nil {
+		return err
+	}
+	err = w.AddOutboxEvent(ctx, tx, webhook.EventOrderDeleted, ord.OrderID, payload)
+	if err != nil {
+		return err
+	}
 	err = tx.Commit()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	w.changed(ord.OrderID)
 	return nil
 }
 
 func (w *SqlWorker) DeleteOrderByID(ctx context.Context, tx *sql.Tx, orderID string) error {
+	ctx, span := startSpan(ctx, "DeleteOrderByID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "DELETE FROM orders WHERE order_id = $1"
 	if tx == nil {
@@ -282,13 +617,20 @@ func (w *SqlWorker) DeleteOrderByID(ctx context.Context, tx *sql.Tx, orderID str
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Delete Order By ID: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
 func (w *SqlWorker) DeletePaymentByID(ctx context.Context, tx *sql.Tx, paymentID string) error {
+	ctx, span := startSpan(ctx, "DeletePaymentByID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "DELETE FROM payments WHERE transaction_id = $1"
 	if tx == nil {
@@ -301,13 +643,20 @@ func (w *SqlWorker) DeletePaymentByID(ctx context.Context, tx *sql.Tx, paymentID
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Delete Payment By ID: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
 func (w *SqlWorker) DeleteItemsByOrderID(ctx context.Context, tx *sql.Tx, orderID string) error {
+	ctx, span := startSpan(ctx, "DeleteItemsByOrderID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "DELETE FROM items WHERE order_id = $1"
 	if tx == nil {
@@ -320,13 +669,20 @@ func (w *SqlWorker) DeleteItemsByOrderID(ctx context.Context, tx *sql.Tx, orderI
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Delete Items By Order ID: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
 func (w *SqlWorker) AddItem(ctx context.Context, tx *sql.Tx, item *item.Item) error {
+	ctx, span := startSpan(ctx, "AddItem")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "INSERT INTO items(chrt_id, track_number, price, rid, name, sale," +
 		"size, total_price, nm_id, brand, order_id) VALUES($1, $2, $3, $4, " +
@@ -347,17 +703,25 @@ func (w *SqlWorker) AddItem(ctx context.Context, tx *sql.Tx, item *item.Item) er
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Add Item query: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
-func (w *SqlWorker) CreateTx() (*sql.Tx, error) {
-	return w.DB.Begin()
+// CreateTx begins tx which is rolled back by database/sql when ctx is done
+func (w *SqlWorker) CreateTx(ctx context.Context) (*sql.Tx, error) {
+	return w.DB.BeginTx(ctx, nil)
 }
 
 func (w *SqlWorker) GetHistoryInterval(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	ctx, span := startSpan(ctx, "GetHistoryInterval")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "SELECT order_id FROM history ORDER BY triggered_at DESC LIMIT $1"
 	var rows *sql.Rows
@@ -371,7 +735,10 @@ func (w *SqlWorker) GetHistoryInterval(ctx context.Context, tx *sql.Tx) ([]strin
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return nil, fmt.Errorf("Problem with execution of Add History Order query: %w", errors.Join(err, rollErr))
 	}
 	defer rows.Close()
@@ -380,7 +747,10 @@ func (w *SqlWorker) GetHistoryInterval(ctx context.Context, tx *sql.Tx) ([]strin
 		var id string
 		err = rows.Scan(&id)
 		if err != nil {
-			rollErr := tx.Rollback()
+			var rollErr error
+			if tx != nil {
+				rollErr = tx.Rollback()
+			}
 			err = fmt.Errorf("Problem with Scan Id history block: %w", errors.Join(rollErr, err))
 			break
 		}
@@ -394,6 +764,10 @@ func (w *SqlWorker) GetHistoryInterval(ctx context.Context, tx *sql.Tx) ([]strin
 }
 
 func (w *SqlWorker) AddPaymentInfo(ctx context.Context, tx *sql.Tx, pay *payment.Payment) error {
+	ctx, span := startSpan(ctx, "AddPaymentInfo")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	query := "INSERT INTO payments(transaction_id, request_id, currency, provider_id, amount, payment_dt," +
 		"bank, delivery_cost, goods_total, custom_fee) VALUES($1, $2, $3, $4, $5, TO_TIMESTAMP($6), $7, $8, $9, $10)"
@@ -411,13 +785,20 @@ func (w *SqlWorker) AddPaymentInfo(ctx context.Context, tx *sql.Tx, pay *payment
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		return fmt.Errorf("Problem with execution of Add PaymentInfo query: %w", errors.Join(err, rollErr))
 	}
 	return nil
 }
 
 func (w *SqlWorker) GetOrderByID(ctx context.Context, tx *sql.Tx, orderID string) (*order.Order, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetOrderByID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var customErr customerrors.CustomError
 	query := "SELECT * FROM orders WHERE order_id = $1"
 	var row *sql.Row
@@ -438,15 +819,26 @@ func (w *SqlWorker) GetOrderByID(ctx context.Context, tx *sql.Tx, orderID string
 		&ord.SmID, &ord.OofShard, &ord.DateCreated,
 	)
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get Order By ID scan: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			customErr.Message = fmt.Sprintf("Order '%s' was not found", orderID)
+			customErr.Status = http.StatusNotFound
+		}
 		return nil, &customErr
 	}
 	return &ord, nil
 }
 
 func (w *SqlWorker) GetPaymentByID(ctx context.Context, tx *sql.Tx, paymentID string) (*payment.Payment, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetPaymentByID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var customErr customerrors.CustomError
 	query := "SELECT * FROM payments WHERE transaction_id = $1"
 	var row *sql.Row
@@ -467,15 +859,22 @@ func (w *SqlWorker) GetPaymentByID(ctx context.Context, tx *sql.Tx, paymentID st
 	)
 	pay.PaymentDateTime = t.Unix()
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get Payment By ID scan: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
 		return nil, &customErr
 	}
 	return &pay, nil
 }
 
 func (w *SqlWorker) GetUserByPhone(ctx context.Context, tx *sql.Tx, phone string) (*user.User, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetUserByPhone")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var customErr customerrors.CustomError
 	query := "SELECT * FROM users WHERE phonenumber = $1"
 	var row *sql.Row
@@ -491,15 +890,22 @@ func (w *SqlWorker) GetUserByPhone(ctx context.Context, tx *sql.Tx, phone string
 	var usr user.User
 	err := row.Scan(&usr.Phonenumber, &usr.Name, &usr.Email, &usr.AddressID)
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get User By Phone scan: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
 		return nil, &customErr
 	}
 	return &usr, nil
 }
 
 func (w *SqlWorker) GetAddressByID(ctx context.Context, tx *sql.Tx, id int64) (*user.Address, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetAddressByID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var customErr customerrors.CustomError
 	query := "SELECT zipcode, city, address, region FROM addresses WHERE id = $1"
 	var row *sql.Row
@@ -515,15 +921,22 @@ func (w *SqlWorker) GetAddressByID(ctx context.Context, tx *sql.Tx, id int64) (*
 	var usr user.Address
 	err := row.Scan(&usr.Zipcode, &usr.City, &usr.Address, &usr.Region)
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get Address By ID scan: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
 		return nil, &customErr
 	}
 	return &usr, nil
 }
 
 func (w *SqlWorker) GetItemsByOrderID(ctx context.Context, tx *sql.Tx, orderID string) ([]item.Item, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetItemsByOrderID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
 	var err error
 	var customErr customerrors.CustomError
 	query := "SELECT * FROM items WHERE order_id = $1"
@@ -538,9 +951,12 @@ func (w *SqlWorker) GetItemsByOrderID(ctx context.Context, tx *sql.Tx, orderID s
 		)
 	}
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get Items By OrderID query: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
 		return nil, &customErr
 	}
 	defer rows.Close()
@@ -552,18 +968,24 @@ func (w *SqlWorker) GetItemsByOrderID(ctx context.Context, tx *sql.Tx, orderID s
 			&itm.Sale, &itm.Size, &itm.TotalPrice, &itm.NmID, &itm.Brand, &itm.OrderID,
 		)
 		if err != nil {
-			rollErr := tx.Rollback()
+			var rollErr error
+			if tx != nil {
+				rollErr = tx.Rollback()
+			}
 			customErr.Message = fmt.Errorf("Problem with execution of Get Items By OrderID scan: %w", errors.Join(err, rollErr)).Error()
-			customErr.Status = http.StatusInternalServerError
+			customErr.Status = failedStatus(ctx, err)
 			return nil, &customErr
 		}
 		itms = append(itms, itm)
 	}
 	err = rows.Err()
 	if err != nil {
-		rollErr := tx.Rollback()
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
 		customErr.Message = fmt.Errorf("Problem with execution of Get Items By OrderID rows.Err: %w", errors.Join(err, rollErr)).Error()
-		customErr.Status = http.StatusInternalServerError
+		customErr.Status = failedStatus(ctx, err)
 		return nil, &customErr
 	}
 
@@ -605,10 +1027,7 @@ func Read(path string) (string, error) {
 
 func (w *SqlWorker) CreateDefaultTables() error {
 	filename := "init.sql"
-	path := filepath.Join(
-		"statics", "queries", filename,
-	)
-	query, err := Read(path)
+	query, err := Read(schemaPath)
 	if err != nil {
 		return fmt.Errorf("Problem with reading of '%s' query: %w", filename, err)
 	}
@@ -618,19 +1037,36 @@ func (w *SqlWorker) CreateDefaultTables() error {
 	if err != nil {
 		return fmt.Errorf("Problem with execution of '%s' query: %w", filename, err)
 	}
-	return nil
+	return w.saveSchemaChecksum(query)
 }
 
 func (w *SqlWorker) GetDataByID(ctx context.Context, id string) (*order.Order, *customerrors.CustomError) {
-	tx, err := w.CreateTx()
+	ctx, span := startSpan(ctx, "GetDataByID")
+	defer span.End()
+	defer metrics.ObservePostgres("GetDataByID", time.Now())
+	span.SetAttributes(tracing.OrderID(id))
+	var ord *order.Order
+	cErr := w.guardCustom(ctx, func() (cErr *customerrors.CustomError) {
+		ord, cErr = w.getDataByID(ctx, id)
+		return cErr
+	})
+	if cErr != nil {
+		tracing.Fail(span, cErr)
+	}
+	return ord, cErr
+}
+
+func (w *SqlWorker) getDataByID(ctx context.Context, id string) (*order.Order, *customerrors.CustomError) {
+	tx, err := w.CreateTx(ctx)
 	if err != nil {
 		cusErr := customerrors.CustomError{
 			Message: "Couldn't create TX",
-			Status:  http.StatusInternalServerError,
+			Status:  failedStatus(ctx, err),
 		}
 		return nil, &cusErr
 	}
-	fmt.Printf("ID '%s' is processing...\n", id)
+	defer tx.Rollback()
+	w.log().Debugw("Order is loading", "order_id", id)
 	ord, cErr := w.GetOrderByID(ctx, tx, id)
 	if cErr != nil {
 		return nil, cErr
@@ -655,14 +1091,137 @@ func (w *SqlWorker) GetDataByID(ctx context.Context, id string) (*order.Order, *
 	if err != nil {
 		cErr = &customerrors.CustomError{
 			Message: err.Error(),
-			Status:  http.StatusInternalServerError,
+			Status:  failedStatus(ctx, err),
 		}
 		return nil, cErr
 	}
-	tx = nil
 	usr.Address = *addr
 	ord.User = usr
 	ord.PaymentInfo = payInfo
 	ord.Items = itms
 	return ord, nil
 }
+
+func (w *SqlWorker) GetOrderIDByTrackNumber(ctx context.Context, tx *sql.Tx, trackNumber string) (string, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetOrderIDByTrackNumber")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetOrderIDByTrackNumber", time.Now())
+	query := "SELECT order_id FROM orders WHERE track_number = $1"
+	return w.getOrderIDBy(ctx, tx, query, trackNumber)
+}
+
+func (w *SqlWorker) GetOrderIDByTransactionID(ctx context.Context, tx *sql.Tx, transactionID string) (string, *customerrors.CustomError) {
+	ctx, span := startSpan(ctx, "GetOrderIDByTransactionID")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetOrderIDByTransactionID", time.Now())
+	query := "SELECT order_id FROM orders WHERE transaction_id = $1"
+	return w.getOrderIDBy(ctx, tx, query, transactionID)
+}
+
+func (w *SqlWorker) getOrderIDBy(ctx context.Context, tx *sql.Tx, query string, value string) (string, *customerrors.CustomError) {
+	var row *sql.Row
+	if tx == nil {
+		row = w.DB.QueryRowContext(
+			ctx, query, value,
+		)
+	} else {
+		row = tx.QueryRowContext(
+			ctx, query, value,
+		)
+	}
+	var id string
+	err := row.Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", &customerrors.CustomError{
+			Message: fmt.Sprintf("Order for '%s' was not found", value),
+			Status:  http.StatusNotFound,
+		}
+	}
+	if err != nil {
+		return "", &customerrors.CustomError{
+			Message: fmt.Errorf("Problem with execution of Get Order ID scan: %w", err).Error(),
+			Status:  failedStatus(ctx, err),
+		}
+	}
+	return id, nil
+}
+
+func (w *SqlWorker) GetHistory(ctx context.Context, tx *sql.Tx, limit int) ([]history.Record, error) {
+	ctx, span := startSpan(ctx, "GetHistory")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetHistory", time.Now())
+	var err error
+	query := "SELECT order_id, triggered_at FROM history ORDER BY triggered_at DESC LIMIT $1"
+	var rows *sql.Rows
+	if tx == nil {
+		rows, err = w.DB.QueryContext(
+			ctx, query, limit,
+		)
+	} else {
+		rows, err = tx.QueryContext(
+			ctx, query, limit,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Get History query: %w", err)
+	}
+	defer rows.Close()
+	records := make([]history.Record, 0, limit)
+	for rows.Next() {
+		var rec history.Record
+		err = rows.Scan(&rec.OrderID, &rec.TriggeredAt)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan history record: %w", err)
+		}
+		records = append(records, rec)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading history rows: %w", err)
+	}
+	return records, nil
+}
+
+func (w *SqlWorker) ListOrderIDs(ctx context.Context, tx *sql.Tx, after string, limit int) ([]string, error) {
+	ctx, span := startSpan(ctx, "ListOrderIDs")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("ListOrderIDs", time.Now())
+	var err error
+	query := "SELECT order_id FROM orders WHERE order_id > $1 ORDER BY order_id LIMIT $2"
+	var rows *sql.Rows
+	if tx == nil {
+		rows, err = w.DB.QueryContext(
+			ctx, query, after, limit,
+		)
+	} else {
+		rows, err = tx.QueryContext(
+			ctx, query, after, limit,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of List Order IDs query: %w", err)
+	}
+	defer rows.Close()
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan order id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading order ids: %w", err)
+	}
+	return ids, nil
+}
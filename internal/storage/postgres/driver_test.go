@@ -4,13 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/akashipov/L0project/internal/breaker"
 	customerrors "github.com/akashipov/L0project/internal/errors"
 	"github.com/akashipov/L0project/internal/storage/order"
-	_ "github.com/lib/pq"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +35,7 @@ func TestSqlWorker_AddData(t *testing.T) {
 		fields  fields
 		args    args
 		waitErr bool
+		errIs   error
 	}{
 		{
 			name: "common_case",
@@ -52,6 +58,7 @@ func TestSqlWorker_AddData(t *testing.T) {
 				dataFilename: "order.json",
 			},
 			waitErr: true,
+			errIs:   ErrAlreadyStored,
 		},
 	}
 	ctx, cancel := context.WithTimeout(ctx, time.Second)
@@ -74,6 +81,7 @@ func TestSqlWorker_AddData(t *testing.T) {
 			err := w.AddData(tt.args.ctx, []byte(data))
 			if tt.waitErr {
 				require.NotEqual(t, nil, err)
+				assert.ErrorIs(t, err, tt.errIs)
 				return
 			} else {
 				require.Equal(t, nil, err)
@@ -90,3 +98,240 @@ func TestSqlWorker_AddData(t *testing.T) {
 		})
 	}
 }
+
+func TestSqlWorker_DeleteDataByOrderID(t *testing.T) {
+	ctx := context.Background()
+	Start(ctx, t)
+	data, err := Read("/statics/test/order.json")
+	require.Equal(t, nil, err)
+	var ord order.Order
+	require.Equal(t, nil, json.Unmarshal([]byte(data), &ord))
+	w := &SqlWorker{DB: DBWorker.DB}
+	require.Equal(t, nil, w.AddData(ctx, []byte(data)))
+	require.Equal(t, nil, w.DeleteDataByOrderID(ctx, []byte(data)))
+	defer w.DB.ExecContext(ctx, "DELETE FROM webhook_outbox WHERE order_id = $1", ord.OrderID)
+
+	rows, err := w.DB.QueryContext(ctx, "SELECT event_type FROM webhook_outbox WHERE order_id = $1 ORDER BY id", ord.OrderID)
+	require.Equal(t, nil, err)
+	defer rows.Close()
+	types := make([]string, 0)
+	for rows.Next() {
+		var eventType string
+		require.Equal(t, nil, rows.Scan(&eventType))
+		types = append(types, eventType)
+	}
+	require.Equal(t, nil, rows.Err())
+	assert.Equal(t, []string{webhook.EventOrderStored, webhook.EventOrderDeleted}, types)
+}
+
+func TestSqlWorker_AddData_Updated(t *testing.T) {
+	ctx := context.Background()
+	Start(ctx, t)
+	data, err := Read("/statics/test/order.json")
+	require.Equal(t, nil, err)
+	var ord order.Order
+	require.Equal(t, nil, json.Unmarshal([]byte(data), &ord))
+	w := &SqlWorker{DB: DBWorker.DB}
+	require.Equal(t, nil, w.AddData(ctx, []byte(data)))
+	defer w.DB.ExecContext(ctx, "DELETE FROM webhook_outbox WHERE order_id = $1", ord.OrderID)
+	defer w.DeleteDataByOrderID(ctx, []byte(data))
+
+	// the same order with other delivery overwrites stored one
+	ord.User.Name = ord.User.Name + " Jr"
+	changed, err := json.Marshal(ord)
+	require.Equal(t, nil, err)
+	changedIDs := make([]string, 0)
+	w.OnChange = func(orderID string) {
+		changedIDs = append(changedIDs, orderID)
+	}
+	require.Equal(t, nil, w.AddData(ctx, changed))
+	assert.ErrorIs(t, w.AddData(ctx, changed), ErrAlreadyStored)
+	assert.Equal(t, []string{ord.OrderID}, changedIDs)
+	stored, cErr := w.GetDataByID(ctx, ord.OrderID)
+	var emptyCErr *customerrors.CustomError
+	require.Equal(t, emptyCErr, cErr)
+	assert.Equal(t, ord.User.Name, stored.User.Name)
+
+	rows, err := w.DB.QueryContext(ctx, "SELECT event_type FROM webhook_outbox WHERE order_id = $1 ORDER BY id", ord.OrderID)
+	require.Equal(t, nil, err)
+	defer rows.Close()
+	types := make([]string, 0)
+	for rows.Next() {
+		var eventType string
+		require.Equal(t, nil, rows.Scan(&eventType))
+		types = append(types, eventType)
+	}
+	require.Equal(t, nil, rows.Err())
+	assert.Equal(t, []string{webhook.EventOrderStored, webhook.EventOrderUpdated}, types)
+}
+
+func TestOrderDigest(t *testing.T) {
+	var a, b order.Order
+	require.Equal(t, nil, json.Unmarshal([]byte(`{"order_uid":"a","delivery":{"name":"x"},"payment":{}}`), &a))
+	require.Equal(t, nil, json.Unmarshal([]byte(`{ "payment": {}, "delivery": {"name": "x"}, "order_uid": "a" }`), &b))
+	da, err := orderDigest(&a)
+	require.Equal(t, nil, err)
+	db, err := orderDigest(&b)
+	require.Equal(t, nil, err)
+	// the same order in other formatting is delivered again
+	assert.Equal(t, da, db)
+	b.User.Name = "y"
+	db, err = orderDigest(&b)
+	require.Equal(t, nil, err)
+	assert.NotEqual(t, da, db)
+}
+
+func TestDecodeOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "valid", data: `{"order_uid":"a","delivery":{},"payment":{}}`},
+		{name: "malformed", data: `{"order_uid":`, wantErr: true},
+		{name: "wrong_type", data: `{"order_uid":1}`, wantErr: true},
+		{name: "no_uid", data: `{"delivery":{},"payment":{}}`, wantErr: true},
+		{name: "no_delivery", data: `{"order_uid":"a","payment":{}}`, wantErr: true},
+		{name: "no_payment", data: `{"order_uid":"a","delivery":{}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeOrder([]byte(tt.data))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidOrder)
+				return
+			}
+			require.Equal(t, nil, err)
+		})
+	}
+}
+
+func TestSqlWorker_insertFailed(t *testing.T) {
+	w := &SqlWorker{}
+	ctx := context.Background()
+	tooLong := fmt.Errorf("Problem with execution of Add Order query: %w", errors.Join(&pq.Error{Code: "22001"}, nil))
+	assert.ErrorIs(t, w.insertFailed(ctx, "a", tooLong), ErrInvalidOrder)
+	noUser := &pq.Error{Code: "23503"}
+	assert.ErrorIs(t, w.insertFailed(ctx, "a", noUser), ErrInvalidOrder)
+	// deadline and failures of Psql db are redelivered, not rejected
+	for _, err := range []error{&pq.Error{Code: "57014"}, context.DeadlineExceeded, ErrUnavailable} {
+		got := w.insertFailed(ctx, "a", err)
+		assert.Equal(t, err, got)
+		assert.False(t, errors.Is(got, ErrInvalidOrder))
+	}
+}
+
+func TestSqlWorker_Wait(t *testing.T) {
+	w := &SqlWorker{}
+	require.Equal(t, nil, w.Wait(context.Background()))
+
+	w.writes.begin()
+	w.writes.begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := w.Wait(ctx)
+	require.NotEqual(t, nil, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Wait(context.Background())
+	}()
+	w.writes.end()
+	select {
+	case <-done:
+		t.Fatal("Wait returned with transaction in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.writes.end()
+	require.Equal(t, nil, <-done)
+}
+
+func TestSqlWorker_queryContext(t *testing.T) {
+	w := &SqlWorker{QueryTimeout: 10 * time.Millisecond}
+	ctx, cancel := w.queryContext(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(10*time.Millisecond), deadline, 5*time.Millisecond)
+	<-ctx.Done()
+	assert.Equal(t, http.StatusGatewayTimeout, int(failedStatus(ctx, ctx.Err())))
+
+	// earlier deadline of caller is kept
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancelParent()
+	ctx, cancel = w.queryContext(parent)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+	deadline, _ = ctx.Deadline()
+	assert.Equal(t, parentDeadline, deadline)
+
+	ctx, cancel = (&SqlWorker{}).queryContext(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusInternalServerError, int(failedStatus(ctx, sql.ErrNoRows)))
+	assert.Equal(t, http.StatusServiceUnavailable, int(failedStatus(ctx, &net.OpError{Op: "dial", Err: errors.New("connection refused")})))
+}
+
+func TestSqlWorker_guard(t *testing.T) {
+	w := &SqlWorker{Breaker: breaker.New(breaker.Config{Failures: 2, OpenTimeout: time.Minute})}
+	ctx := context.Background()
+	calls := 0
+	refused := func() error {
+		calls++
+		return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	}
+	// failures of single call don't open breaker
+	err := w.guard(ctx, func() error { return &pq.Error{Code: "23505"} })
+	require.NotEqual(t, nil, err)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	w.guard(canceled, func() error { return canceled.Err() })
+	// deadline of nats message or http request is not failure of Psql db
+	expired, cancelExpired := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancelExpired()
+	<-expired.Done()
+	for i := 0; i < 3; i++ {
+		err = w.guard(expired, func() error { return &pq.Error{Code: "57014"} })
+		assert.False(t, errors.Is(err, ErrUnavailable))
+		w.guard(expired, func() error { return expired.Err() })
+	}
+	assert.Equal(t, breaker.StateClosed, w.Breaker.State())
+	// QueryTimeout expires while caller still waits
+	queryTimeout := func() error {
+		qctx, cancel := (&SqlWorker{QueryTimeout: time.Nanosecond}).queryContext(ctx)
+		defer cancel()
+		<-qctx.Done()
+		return fmt.Errorf("Problem with execution of query: %w", qctx.Err())
+	}
+	assert.ErrorIs(t, w.guard(ctx, queryTimeout), ErrUnavailable)
+	assert.Equal(t, breaker.StateClosed, w.Breaker.State())
+	w.guard(ctx, func() error { return nil })
+	// route deadline answered with 504 is not failure of Psql db
+	for i := 0; i < 3; i++ {
+		cErr := w.guardCustom(expired, func() *customerrors.CustomError {
+			return &customerrors.CustomError{Message: "Couldn't create TX", Status: failedStatus(expired, expired.Err())}
+		})
+		require.NotNil(t, cErr)
+		assert.Equal(t, http.StatusGatewayTimeout, int(cErr.Status))
+	}
+	assert.Equal(t, breaker.StateClosed, w.Breaker.State())
+
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, w.guard(ctx, refused), ErrUnavailable)
+	}
+	assert.True(t, w.Unavailable())
+	err = w.guard(ctx, refused)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+	assert.Equal(t, 2, calls)
+
+	cErr := w.guardCustom(ctx, func() *customerrors.CustomError {
+		t.Fatal("Psql db is called while breaker is open")
+		return nil
+	})
+	require.NotNil(t, cErr)
+	assert.Equal(t, http.StatusServiceUnavailable, int(cErr.Status))
+}
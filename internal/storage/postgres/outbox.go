@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/akashipov/L0project/internal/storage/outbox"
+	"github.com/lib/pq"
+)
+
+// AddNatsOutboxMessage has to be called in tx of order, so message is relayed only for committed order
+func (w *SqlWorker) AddNatsOutboxMessage(ctx context.Context, tx *sql.Tx, subject string, orderID string, payload []byte) error {
+	ctx, span := startSpan(ctx, "AddNatsOutboxMessage")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	var err error
+	query := "INSERT INTO nats_outbox(subject, order_id, payload) VALUES($1, $2, $3)"
+	if tx == nil {
+		_, err = w.DB.ExecContext(
+			ctx, query, subject, orderID, payload,
+		)
+	} else {
+		_, err = tx.ExecContext(
+			ctx, query, subject, orderID, payload,
+		)
+	}
+	if err != nil {
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
+		return fmt.Errorf("Problem with execution of Add Nats Outbox query: %w", errors.Join(err, rollErr))
+	}
+	return nil
+}
+
+// RelayNatsOutbox locks pending messages, passes them to publish and marks them relayed
+// when publish succeeded. Messages are kept locked while publishing, so replicas don't relay them twice,
+// publish has to return before deadline of ctx to keep tx short
+func (w *SqlWorker) RelayNatsOutbox(ctx context.Context, limit int, publish func(ctx context.Context, msgs []outbox.Message) (int, error)) (int, error) {
+	ctx, span := startSpan(ctx, "RelayNatsOutbox")
+	defer span.End()
+	defer metrics.ObservePostgres("RelayNatsOutbox", time.Now())
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with creation of tx: %w", err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, subject, order_id, payload, created_at FROM nats_outbox "+
+			"WHERE relayed_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED",
+		limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with execution of Get Nats Outbox query: %w", err)
+	}
+	msgs := make([]outbox.Message, 0, limit)
+	for rows.Next() {
+		var m outbox.Message
+		var payload []byte
+		err = rows.Scan(&m.ID, &m.Subject, &m.OrderID, &payload, &m.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Problem with Scan nats outbox message: %w", err)
+		}
+		m.Payload = payload
+		msgs = append(msgs, m)
+	}
+	err = errors.Join(rows.Err(), rows.Close())
+	if err != nil {
+		return 0, fmt.Errorf("Problem with reading nats outbox: %w", err)
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	// published prefix is marked even if the rest failed
+	n, pubErr := publish(ctx, msgs)
+	if n > 0 {
+		ids := make([]int64, 0, n)
+		for _, m := range msgs[:n] {
+			ids = append(ids, m.ID)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE nats_outbox SET relayed_at = NOW() WHERE id = ANY($1)", pq.Array(ids))
+		if err != nil {
+			return 0, fmt.Errorf("Problem with execution of Mark Relayed query: %w", errors.Join(err, pubErr))
+		}
+		err = tx.Commit()
+		if err != nil {
+			return 0, fmt.Errorf("Problem with commit of relayed messages: %w", errors.Join(err, pubErr))
+		}
+	}
+	return n, pubErr
+}
+
+func (w *SqlWorker) GetNatsOutboxLag(ctx context.Context) (outbox.Lag, error) {
+	ctx, span := startSpan(ctx, "GetNatsOutboxLag")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetNatsOutboxLag", time.Now())
+	var lag outbox.Lag
+	row := w.DB.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)), 0) "+
+			"FROM nats_outbox WHERE relayed_at IS NULL",
+	)
+	err := row.Scan(&lag.Pending, &lag.OldestPendingSec)
+	if err != nil {
+		return lag, fmt.Errorf("Problem with execution of Get Nats Outbox Lag query: %w", err)
+	}
+	return lag, nil
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var schemaPath = filepath.Join("statics", "queries", "init.sql")
+
+func schemaChecksum(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// saveSchemaChecksum remembers which init.sql was applied to Psql db
+func (w *SqlWorker) saveSchemaChecksum(query string) error {
+	_, err := w.DB.Exec(
+		"INSERT INTO schema_version(id, checksum, applied_at) VALUES(1, $1, NOW()) "+
+			"ON CONFLICT (id) DO UPDATE SET checksum = $1, applied_at = NOW()",
+		schemaChecksum(query),
+	)
+	if err != nil {
+		return fmt.Errorf("Problem with saving of schema version: %w", err)
+	}
+	return nil
+}
+
+// CheckSchema fails when init.sql of this server was not applied to Psql db,
+// e.g. another replica applied a different version of it
+func (w *SqlWorker) CheckSchema(ctx context.Context) error {
+	query, err := Read(schemaPath)
+	if err != nil {
+		return err
+	}
+	var applied string
+	err = w.DB.QueryRowContext(ctx, "SELECT checksum FROM schema_version WHERE id = 1").Scan(&applied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("Schema was not applied")
+	}
+	if err != nil {
+		return fmt.Errorf("Problem with getting of schema version: %w", err)
+	}
+	if applied != schemaChecksum(query) {
+		return fmt.Errorf("Schema is outdated: applied %.12s, expected %.12s", applied, schemaChecksum(query))
+	}
+	return nil
+}
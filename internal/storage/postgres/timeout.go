@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"context"
+	"net/http"
+)
+
+// queryContext bounds one query by QueryTimeout, deadline of ctx is kept when it is earlier
+func (w *SqlWorker) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if w.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, w.QueryTimeout)
+}
+
+// failedStatus tells apart queries stopped by deadline or cancellation and
+// unavailable Psql db from other failures
+func failedStatus(ctx context.Context, err error) http.ConnState {
+	if ctx.Err() != nil {
+		return http.StatusGatewayTimeout
+	}
+	if unavailable(ctx, err) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,347 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/akashipov/L0project/internal/metrics"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/lib/pq"
+)
+
+func (w *SqlWorker) AddWebhook(ctx context.Context, tx *sql.Tx, hook *webhook.Webhook) error {
+	ctx, span := startSpan(ctx, "AddWebhook")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("AddWebhook", time.Now())
+	query := "INSERT INTO webhooks(url, secret, event_types) VALUES($1, $2, $3) RETURNING id, created_at"
+	var row *sql.Row
+	if tx == nil {
+		row = w.DB.QueryRowContext(
+			ctx, query, hook.URL, hook.Secret, pq.Array(hook.EventTypes),
+		)
+	} else {
+		row = tx.QueryRowContext(
+			ctx, query, hook.URL, hook.Secret, pq.Array(hook.EventTypes),
+		)
+	}
+	err := row.Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Problem with execution of Add Webhook query: %w", err)
+	}
+	return nil
+}
+
+func (w *SqlWorker) GetWebhooks(ctx context.Context, tx *sql.Tx) ([]webhook.Webhook, error) {
+	ctx, span := startSpan(ctx, "GetWebhooks")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetWebhooks", time.Now())
+	var err error
+	query := "SELECT id, url, secret, event_types, created_at FROM webhooks ORDER BY id"
+	var rows *sql.Rows
+	if tx == nil {
+		rows, err = w.DB.QueryContext(ctx, query)
+	} else {
+		rows, err = tx.QueryContext(ctx, query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Get Webhooks query: %w", err)
+	}
+	defer rows.Close()
+	hooks := make([]webhook.Webhook, 0)
+	for rows.Next() {
+		var hook webhook.Webhook
+		err = rows.Scan(&hook.ID, &hook.URL, &hook.Secret, pq.Array(&hook.EventTypes), &hook.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan webhook: %w", err)
+		}
+		hooks = append(hooks, hook)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+func (w *SqlWorker) DeleteWebhook(ctx context.Context, tx *sql.Tx, id int64) (bool, error) {
+	ctx, span := startSpan(ctx, "DeleteWebhook")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("DeleteWebhook", time.Now())
+	var err error
+	var res sql.Result
+	query := "DELETE FROM webhooks WHERE id = $1"
+	if tx == nil {
+		res, err = w.DB.ExecContext(ctx, query, id)
+	} else {
+		res, err = tx.ExecContext(ctx, query, id)
+	}
+	if err != nil {
+		return false, fmt.Errorf("Problem with execution of Delete Webhook query: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Problem with getting deleted webhooks: %w", err)
+	}
+	return n > 0, nil
+}
+
+// AddOutboxEvent has to be called in tx of order change, so event is saved only with the change
+func (w *SqlWorker) AddOutboxEvent(ctx context.Context, tx *sql.Tx, eventType string, orderID string, payload []byte) error {
+	ctx, span := startSpan(ctx, "AddOutboxEvent")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	var err error
+	query := "INSERT INTO webhook_outbox(event_type, order_id, payload) VALUES($1, $2, $3)"
+	if tx == nil {
+		_, err = w.DB.ExecContext(
+			ctx, query, eventType, orderID, payload,
+		)
+	} else {
+		_, err = tx.ExecContext(
+			ctx, query, eventType, orderID, payload,
+		)
+	}
+	if err != nil {
+		var rollErr error
+		if tx != nil {
+			rollErr = tx.Rollback()
+		}
+		return fmt.Errorf("Problem with execution of Add Outbox Event query: %w", errors.Join(err, rollErr))
+	}
+	return nil
+}
+
+// AddRejectedEvent saves to outbox message from NATS which couldn't be stored
+func (w *SqlWorker) AddRejectedEvent(ctx context.Context, data []byte, reason error) error {
+	ctx, span := startSpan(ctx, "AddRejectedEvent")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("AddRejectedEvent", time.Now())
+	var ord order.Order
+	// order uid is reported when message is a json at least
+	json.Unmarshal(data, &ord)
+	payload, err := json.Marshal(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{Error: reason.Error(), Message: string(data)})
+	if err != nil {
+		return fmt.Errorf("Problem with encoding of rejected event: %w", err)
+	}
+	return w.AddOutboxEvent(ctx, nil, webhook.EventOrderRejected, ord.OrderID, payload)
+}
+
+// DispatchOutbox creates deliveries of pending outbox events for every subscribed webhook
+// and returns number of dispatched events
+func (w *SqlWorker) DispatchOutbox(ctx context.Context, limit int) (int, error) {
+	ctx, span := startSpan(ctx, "DispatchOutbox")
+	defer span.End()
+	defer metrics.ObservePostgres("DispatchOutbox", time.Now())
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with creation of tx: %w", err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id FROM webhook_outbox WHERE dispatched_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED",
+		limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with execution of Get Pending Outbox query: %w", err)
+	}
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Problem with Scan outbox id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	err = errors.Join(rows.Err(), rows.Close())
+	if err != nil {
+		return 0, fmt.Errorf("Problem with reading outbox ids: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO webhook_deliveries(webhook_id, outbox_id) "+
+			"SELECT h.id, o.id FROM webhook_outbox o JOIN webhooks h "+
+			"ON cardinality(h.event_types) = 0 OR o.event_type = ANY(h.event_types) "+
+			"WHERE o.id = ANY($1) ON CONFLICT DO NOTHING",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Problem with execution of Add Deliveries query: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE webhook_outbox SET dispatched_at = NOW() WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("Problem with execution of Mark Outbox Dispatched query: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("Problem with commit of dispatched outbox: %w", err)
+	}
+	return len(ids), nil
+}
+
+// ClaimDueDeliveries leases due deliveries for lease time, so other replicas skip them
+// and they are retried if process dies in the middle of sending
+func (w *SqlWorker) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhook.Delivery, error) {
+	ctx, span := startSpan(ctx, "ClaimDueDeliveries")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("ClaimDueDeliveries", time.Now())
+	query := "WITH claimed AS (" +
+		"UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond' " +
+		"WHERE id IN (SELECT id FROM webhook_deliveries WHERE delivered_at IS NULL AND next_attempt_at <= NOW() " +
+		"ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED) " +
+		"RETURNING id, webhook_id, outbox_id, attempts) " +
+		"SELECT c.id, c.attempts, h.id, h.url, h.secret, o.id, o.event_type, o.order_id, o.created_at, o.payload " +
+		"FROM claimed c JOIN webhooks h ON h.id = c.webhook_id JOIN webhook_outbox o ON o.id = c.outbox_id"
+	rows, err := w.DB.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Claim Deliveries query: %w", err)
+	}
+	defer rows.Close()
+	deliveries := make([]webhook.Delivery, 0, limit)
+	for rows.Next() {
+		var d webhook.Delivery
+		var data []byte
+		err = rows.Scan(
+			&d.ID, &d.Attempts, &d.Webhook.ID, &d.Webhook.URL, &d.Webhook.Secret,
+			&d.Event.ID, &d.Event.Type, &d.Event.OrderID, &d.Event.CreatedAt, &data,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan delivery: %w", err)
+		}
+		d.Event.Data = data
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (w *SqlWorker) MarkDeliverySucceeded(ctx context.Context, id int64, attempts int) error {
+	ctx, span := startSpan(ctx, "MarkDeliverySucceeded")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("MarkDeliverySucceeded", time.Now())
+	_, err := w.DB.ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET delivered_at = NOW(), attempts = $2, last_error = '' WHERE id = $1",
+		id, attempts,
+	)
+	if err != nil {
+		return fmt.Errorf("Problem with execution of Mark Delivery Succeeded query: %w", err)
+	}
+	return nil
+}
+
+func (w *SqlWorker) MarkDeliveryFailed(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error {
+	ctx, span := startSpan(ctx, "MarkDeliveryFailed")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("MarkDeliveryFailed", time.Now())
+	_, err := w.DB.ExecContext(
+		ctx,
+		"UPDATE webhook_deliveries SET attempts = $2, next_attempt_at = $3, last_error = $4 WHERE id = $1",
+		id, attempts, next, lastErr,
+	)
+	if err != nil {
+		return fmt.Errorf("Problem with execution of Mark Delivery Failed query: %w", err)
+	}
+	return nil
+}
+
+// MoveDeliveryToDeadLetters stops retries of delivery which reached attempts limit
+func (w *SqlWorker) MoveDeliveryToDeadLetters(ctx context.Context, d *webhook.Delivery, attempts int, lastErr string) error {
+	ctx, span := startSpan(ctx, "MoveDeliveryToDeadLetters")
+	defer span.End()
+	defer metrics.ObservePostgres("MoveDeliveryToDeadLetters", time.Now())
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Problem with creation of tx: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO webhook_dead_letters(webhook_id, outbox_id, event_type, order_id, payload, attempts, last_error) "+
+			"VALUES($1, $2, $3, $4, $5, $6, $7)",
+		d.Webhook.ID, d.Event.ID, d.Event.Type, d.Event.OrderID, []byte(d.Event.Data), attempts, lastErr,
+	)
+	if err != nil {
+		return fmt.Errorf("Problem with execution of Add Dead Letter query: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE id = $1", d.ID)
+	if err != nil {
+		return fmt.Errorf("Problem with execution of Delete Delivery query: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Problem with commit of dead letter: %w", err)
+	}
+	return nil
+}
+
+func (w *SqlWorker) GetDeadLetters(ctx context.Context, tx *sql.Tx, limit int) ([]webhook.DeadLetter, error) {
+	ctx, span := startSpan(ctx, "GetDeadLetters")
+	defer span.End()
+	ctx, cancel := w.queryContext(ctx)
+	defer cancel()
+	defer metrics.ObservePostgres("GetDeadLetters", time.Now())
+	var err error
+	query := "SELECT id, webhook_id, outbox_id, event_type, order_id, payload, attempts, last_error, failed_at " +
+		"FROM webhook_dead_letters ORDER BY id DESC LIMIT $1"
+	var rows *sql.Rows
+	if tx == nil {
+		rows, err = w.DB.QueryContext(ctx, query, limit)
+	} else {
+		rows, err = tx.QueryContext(ctx, query, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with execution of Get Dead Letters query: %w", err)
+	}
+	defer rows.Close()
+	letters := make([]webhook.DeadLetter, 0)
+	for rows.Next() {
+		var l webhook.DeadLetter
+		var data []byte
+		err = rows.Scan(
+			&l.ID, &l.WebhookID, &l.EventID, &l.EventType, &l.OrderID,
+			&data, &l.Attempts, &l.LastError, &l.FailedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Problem with Scan dead letter: %w", err)
+		}
+		l.Data = data
+		letters = append(letters, l)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Problem with reading dead letters: %w", err)
+	}
+	return letters, nil
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// writes counts transactions which change orders, so shutdown can wait for them
+type writes struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed when last in-flight transaction is finished
+	idle chan struct{}
+}
+
+func (t *writes) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.n == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.n++
+}
+
+func (t *writes) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n--
+	if t.n == 0 {
+		close(t.idle)
+	}
+}
+
+func (t *writes) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	idle := t.idle
+	t.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return fmt.Errorf("%d transactions are still in flight: %w", t.n, ctx.Err())
+	}
+}
+
+// Wait blocks until in-flight AddData and DeleteDataByOrderID are committed
+// or rolled back, or ctx is done
+func (w *SqlWorker) Wait(ctx context.Context) error {
+	return w.writes.wait(ctx)
+}
+
+// Close waits for in-flight transactions and closes pool of connections
+func (w *SqlWorker) Close(ctx context.Context) error {
+	err := w.Wait(ctx)
+	if err != nil {
+		w.log().Warnw("Pool is closed with in-flight transactions", "error", err)
+	}
+	return w.DB.Close()
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventOrderUpdated is sent when stored order is received again with other content
+// or delivery of its user is changed by other order
+const (
+	EventOrderStored   = "order.stored"
+	EventOrderUpdated  = "order.updated"
+	EventOrderDeleted  = "order.deleted"
+	EventOrderRejected = "order.rejected"
+)
+
+var EventTypes = []string{EventOrderStored, EventOrderUpdated, EventOrderDeleted, EventOrderRejected}
+
+// Webhook is an endpoint registered by downstream system,
+// empty EventTypes means all of event types
+type Webhook struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Event is a row of outbox written in the same tx as order change
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	OrderID   string          `json:"order_uid"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Delivery is a pending attempt to send event to webhook
+type Delivery struct {
+	ID       int64
+	Attempts int
+	Webhook  Webhook
+	Event    Event
+}
+
+type DeadLetter struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhook_id"`
+	EventID   int64           `json:"event_id"`
+	EventType string          `json:"event_type"`
+	OrderID   string          `json:"order_uid"`
+	Data      json.RawMessage `json:"data"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets propagator read and write trace context in nats headers
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject puts trace context of ctx to headers of m
+func Inject(ctx context.Context, m *nats.Msg) {
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(m.Header))
+}
+
+// StartReceive continues trace of publisher with span of received message
+func StartReceive(m *nats.Msg) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if m.Header != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(m.Header))
+	}
+	return Start(ctx, "nats receive "+m.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", m.Subject),
+			attribute.Int("messaging.message.body.size", len(m.Data)),
+		),
+	)
+}
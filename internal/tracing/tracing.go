@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Exporters of spans
+const (
+	ExporterNone     = "none"
+	ExporterStdout   = "stdout"
+	ExporterOTLPFile = "otlp-file"
+	ExporterMemory   = "memory"
+)
+
+const instrumentation = "github.com/akashipov/L0project"
+
+type Config struct {
+	Exporter    string
+	ServiceName string
+	// Path is file of 'otlp-file' exporter
+	Path string
+	// SampleRatio is fraction of new traces which are recorded,
+	// traces started by caller keep its decision
+	SampleRatio float64
+}
+
+// Memory keeps spans of 'memory' exporter, it is used by tests
+var Memory *tracetest.InMemoryExporter
+
+// Init sets global tracer provider and propagator, returned func flushes
+// spans and closes exporter
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	var err error
+	batch := true
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterOTLPFile:
+		exporter, err = otlptrace.New(ctx, NewFileClient(cfg.Path))
+	case ExporterMemory:
+		Memory = tracetest.NewInMemoryExporter()
+		exporter = Memory
+		batch = false
+	default:
+		return nil, fmt.Errorf("Unknown trace exporter '%s'", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with creation of trace exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("Problem with trace resource: %w", err)
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+	if batch {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	} else {
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Start begins span of the default tracer
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// Fail marks span as failed by err, nil err is ignored
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Detach keeps span of ctx without its cancellation and deadline
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// OrderID is attribute shared by spans of one order
+func OrderID(id string) attribute.KeyValue {
+	return attribute.String("order_id", id)
+}
+
+// FileClient writes spans as OTLP json, one ExportTraceServiceRequest per line
+type FileClient struct {
+	path string
+	mu   sync.Mutex
+	w    io.WriteCloser
+}
+
+func NewFileClient(path string) *FileClient {
+	return &FileClient{path: path}
+}
+
+func (c *FileClient) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("Problem with opening of trace file: %w", err)
+	}
+	c.w = f
+	return nil
+}
+
+func (c *FileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return nil
+	}
+	err := c.w.Close()
+	c.w = nil
+	return err
+}
+
+func (c *FileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	data, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return fmt.Errorf("Problem with encoding of spans: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return errors.New("Trace file is closed")
+	}
+	_, err = c.w.Write(append(data, '\n'))
+	return err
+}
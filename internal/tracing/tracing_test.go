@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func useMemory(t *testing.T) *tracetest.InMemoryExporter {
+	shutdown, err := Init(context.Background(), Config{Exporter: ExporterMemory, ServiceName: "test", SampleRatio: 1})
+	require.Equal(t, nil, err)
+	t.Cleanup(func() { shutdown(context.Background()) })
+	return Memory
+}
+
+func attr(attrs []attribute.KeyValue, key string) attribute.Value {
+	for _, kv := range attrs {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestHandle(t *testing.T) {
+	exp := useMemory(t)
+	r := chi.NewRouter()
+	r.Get("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "cache lookup")
+		span.End()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/order/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Handle(r).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exp.GetSpans()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "HTTP GET /order/{id}", server.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, int64(http.StatusServiceUnavailable), attr(server.Attributes, "http.response.status_code").AsInt64())
+	assert.Equal(t, "/order/{id}", attr(server.Attributes, "http.route").AsString())
+	assert.Equal(t, codes.Error, server.Status.Code)
+	assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID())
+}
+
+func TestNatsPropagation(t *testing.T) {
+	exp := useMemory(t)
+	ctx, publish := Start(context.Background(), "nats publish foo")
+	m := &nats.Msg{Subject: "foo", Data: []byte("{}")}
+	Inject(ctx, m)
+	publish.End()
+	assert.NotEqual(t, "", m.Header.Get("traceparent"))
+
+	_, receive := StartReceive(m)
+	Fail(receive, errors.New("bad order"))
+	receive.End()
+
+	spans := exp.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext.TraceID(), spans[1].SpanContext.TraceID())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+	assert.Equal(t, "nats receive foo", spans[1].Name)
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+	require.Len(t, spans[1].Events, 1)
+}
+
+func TestOTLPFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Init(context.Background(), Config{Exporter: ExporterOTLPFile, ServiceName: "test", Path: path, SampleRatio: 1})
+	require.Equal(t, nil, err)
+	_, span := Start(context.Background(), "postgres AddData")
+	span.SetAttributes(OrderID("b563feb7b2b84b6test"))
+	span.End()
+	require.Equal(t, nil, shutdown(context.Background()))
+
+	f, err := os.Open(path)
+	require.Equal(t, nil, err)
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	require.True(t, sc.Scan())
+	var req coltracepb.ExportTraceServiceRequest
+	require.Equal(t, nil, protojson.Unmarshal(sc.Bytes(), &req))
+	require.Len(t, req.ResourceSpans, 1)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	assert.Equal(t, "postgres AddData", spans[0].Name)
+	assert.Equal(t, "b563feb7b2b84b6test", spans[0].Attributes[0].Value.GetStringValue())
+}
+
+func TestInit_UnknownExporter(t *testing.T) {
+	_, err := Init(context.Background(), Config{Exporter: "jaeger"})
+	assert.NotEqual(t, nil, err)
+}
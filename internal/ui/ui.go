@@ -0,0 +1,212 @@
+package ui
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/akashipov/L0project/internal/storage/history"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// RecentLimit is a number of recent lookups shown on the index page
+const RecentLimit = 20
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+const (
+	SearchByID          = "id"
+	SearchByTrack       = "track"
+	SearchByTransaction = "transaction"
+)
+
+// Base is path the UI is mounted on, links of pages start with it
+type indexPage struct {
+	Base   string
+	Query  string
+	By     string
+	Error  string
+	Recent []history.Record
+}
+
+type orderPage struct {
+	Base  string
+	Query string
+	By    string
+	Order *order.Order
+}
+
+type UI struct {
+	Log       *zap.SugaredLogger
+	indexTmpl *template.Template
+	orderTmpl *template.Template
+}
+
+func NewUI(log *zap.SugaredLogger) *UI {
+	funcs := template.FuncMap{
+		"unix": func(t int64) string {
+			return time.Unix(t, 0).UTC().Format(time.RFC3339)
+		},
+		"datetime": func(t time.Time) string {
+			return t.UTC().Format("2006-01-02 15:04:05")
+		},
+	}
+	indexTmpl := template.Must(template.New("layout.html").Funcs(funcs).ParseFS(
+		templatesFS, "templates/layout.html", "templates/index.html",
+	))
+	orderTmpl := template.Must(template.New("layout.html").Funcs(funcs).ParseFS(
+		templatesFS, "templates/layout.html", "templates/order.html",
+	))
+	return &UI{Log: log, indexTmpl: indexTmpl, orderTmpl: orderTmpl}
+}
+
+// Router serves pages relative to the mount point, e.g. '/ui'
+func (u *UI) Router() http.Handler {
+	r := chi.NewRouter()
+	static, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.FileServer(http.FS(static))
+	r.Get("/", u.Index)
+	r.Get("/search", u.Search)
+	r.Get("/order/{id}", u.Order)
+	r.Get("/static/*", func(w http.ResponseWriter, r *http.Request) {
+		http.StripPrefix(mountPath(r)+"/static/", files).ServeHTTP(w, r)
+	})
+	return r
+}
+
+// mountPath is the part of request path routed to the mount point of UI
+func mountPath(r *http.Request) string {
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	// router which is not mounted routes the whole path
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePath == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path, rctx.RoutePath)
+}
+
+// publicError keeps details of server failures, e.g. errors of Psql db, out of pages, they are logged
+func (u *UI) publicError(cErr *customerrors.CustomError) string {
+	if cErr.Status < http.StatusInternalServerError {
+		return cErr.Message
+	}
+	u.Log.Errorw("Problem with serving of page", "status", cErr.Status, "error", cErr.Message)
+	return "Orders are unavailable now, try again later"
+}
+
+func (u *UI) Index(w http.ResponseWriter, r *http.Request) {
+	page := indexPage{Base: mountPath(r), By: SearchByID}
+	u.renderIndex(r.Context(), w, http.StatusOK, page)
+}
+
+func (u *UI) Search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	by := r.URL.Query().Get("by")
+	base := mountPath(r)
+	page := indexPage{Base: base, Query: query, By: by}
+	if query == "" {
+		page.Error = "Search query is empty"
+		u.renderIndex(r.Context(), w, http.StatusBadRequest, page)
+		return
+	}
+	var id string
+	var cErr *customerrors.CustomError
+	switch by {
+	case SearchByID, "":
+		id = query
+	case SearchByTrack:
+		id, cErr = postgres.DBWorker.GetOrderIDByTrackNumber(r.Context(), nil, query)
+	case SearchByTransaction:
+		id, cErr = postgres.DBWorker.GetOrderIDByTransactionID(r.Context(), nil, query)
+	default:
+		page.Error = "Unknown search type: " + by
+		u.renderIndex(r.Context(), w, http.StatusBadRequest, page)
+		return
+	}
+	if cErr != nil {
+		page.Error = u.publicError(cErr)
+		u.renderIndex(r.Context(), w, int(cErr.Status), page)
+		return
+	}
+	http.Redirect(w, r, base+"/order/"+url.PathEscape(id), http.StatusSeeOther)
+}
+
+func (u *UI) Order(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	ctx := r.Context()
+	base := mountPath(r)
+	ord, cErr := u.loadOrder(ctx, id)
+	if cErr != nil {
+		page := indexPage{Base: base, Query: id, By: SearchByID, Error: u.publicError(cErr)}
+		u.renderIndex(ctx, w, int(cErr.Status), page)
+		return
+	}
+	err := postgres.DBWorker.AddOrderHistory(ctx, nil, id, time.Now().Unix())
+	if err != nil {
+		u.Log.Warnf("Problem with saving of lookup to history: %s", err.Error())
+	}
+	u.render(w, u.orderTmpl, http.StatusOK, orderPage{Base: base, Query: id, By: SearchByID, Order: ord})
+}
+
+func (u *UI) loadOrder(ctx context.Context, id string) (*order.Order, *customerrors.CustomError) {
+	data, cErr := cache.GetOrder(ctx, id)
+	if cErr != nil {
+		return nil, cErr
+	}
+	var ord order.Order
+	err := json.Unmarshal(data, &ord)
+	if err != nil {
+		return nil, &customerrors.CustomError{
+			Message: err.Error(),
+			Status:  http.StatusInternalServerError,
+		}
+	}
+	return &ord, nil
+}
+
+func (u *UI) renderIndex(ctx context.Context, w http.ResponseWriter, status int, page indexPage) {
+	recent, err := postgres.DBWorker.GetHistory(ctx, nil, RecentLimit)
+	if err != nil {
+		u.Log.Warnf("Problem with getting recent lookups: %s", err.Error())
+	}
+	page.Recent = recent
+	u.render(w, u.indexTmpl, status, page)
+}
+
+func (u *UI) render(w http.ResponseWriter, tmpl *template.Template, status int, data any) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		u.Log.Errorw("Problem with rendering of page", "error", err)
+		cErr := customerrors.CustomError{
+			Message: "Page couldn't be rendered",
+			Status:  http.StatusInternalServerError,
+		}
+		cErr.ReportError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,262 @@
+package ui
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/storage/cache"
+	"github.com/akashipov/L0project/internal/storage/history"
+	"github.com/akashipov/L0project/internal/storage/order"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const (
+	testOrderID     = "b563feb7b2b84b6t428"
+	testTrackNumber = "WBILMTESTTR428"
+)
+
+var testOrderJSON = filepath.Join("statics", "test", "TestGetOrder_common_case.json")
+
+func newServer(log *zap.SugaredLogger) *httptest.Server {
+	r := chi.NewRouter()
+	r.Mount("/ui", NewUI(log).Router())
+	return httptest.NewServer(r)
+}
+
+// get does not follow redirects, so the search result is checked as is
+func get(t *testing.T, rawURL string) (*http.Response, string) {
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(rawURL)
+	require.Equal(t, nil, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.Equal(t, nil, err)
+	return res, string(body)
+}
+
+func TestUI(t *testing.T) {
+	ctx := context.Background()
+	postgres.Start(ctx, t)
+	cache.InitCache(ctx, postgres.Log)
+	srv := newServer(postgres.Log)
+	defer srv.Close()
+	b, err := postgres.Read(testOrderJSON)
+	require.Equal(t, nil, err)
+	err = postgres.DBWorker.AddData(ctx, []byte(b))
+	defer func() {
+		postgres.DBWorker.DeleteDataByOrderID(ctx, []byte(b))
+		postgres.DBWorker.DeleteOrderHistory(ctx, nil, testOrderID)
+	}()
+	require.Equal(t, nil, err)
+
+	search := []struct {
+		name     string
+		by       string
+		query    string
+		status   int
+		location string
+		contains string
+	}{
+		{
+			name:     "by_id",
+			by:       SearchByID,
+			query:    testOrderID,
+			status:   http.StatusSeeOther,
+			location: "/ui/order/" + testOrderID,
+		},
+		{
+			name:     "by_track",
+			by:       SearchByTrack,
+			query:    testTrackNumber,
+			status:   http.StatusSeeOther,
+			location: "/ui/order/" + testOrderID,
+		},
+		{
+			name:     "by_transaction",
+			by:       SearchByTransaction,
+			query:    testOrderID,
+			status:   http.StatusSeeOther,
+			location: "/ui/order/" + testOrderID,
+		},
+		{
+			name:   "track_not_found",
+			by:     SearchByTrack,
+			query:  "UNKNOWNTRACK",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "transaction_not_found",
+			by:     SearchByTransaction,
+			query:  "unknown_transaction",
+			status: http.StatusNotFound,
+		},
+		{
+			name:     "empty_query",
+			by:       SearchByID,
+			query:    " ",
+			status:   http.StatusBadRequest,
+			contains: "Search query is empty",
+		},
+		{
+			name:     "unknown_type",
+			by:       "phone",
+			query:    testOrderID,
+			status:   http.StatusBadRequest,
+			contains: "Unknown search type: phone",
+		},
+	}
+	for _, tt := range search {
+		t.Run("search_"+tt.name, func(t *testing.T) {
+			q := url.Values{"q": {tt.query}, "by": {tt.by}}
+			res, body := get(t, srv.URL+"/ui/search?"+q.Encode())
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, tt.location, res.Header.Get("Location"))
+			assert.Contains(t, body, tt.contains)
+		})
+	}
+
+	t.Run("order", func(t *testing.T) {
+		res, body := get(t, srv.URL+"/ui/order/"+testOrderID)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Contains(t, body, "Order "+testOrderID)
+		assert.Contains(t, body, testTrackNumber)
+		assert.Contains(t, body, "<h3>Items</h3>")
+		assert.Contains(t, body, "Mascaras")
+		assert.Contains(t, body, "Vivienne Sabo")
+	})
+
+	t.Run("order_not_found", func(t *testing.T) {
+		res, body := get(t, srv.URL+"/ui/order/unknown_order")
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Contains(t, body, "Recent lookups")
+	})
+
+	t.Run("index", func(t *testing.T) {
+		res, body := get(t, srv.URL+"/ui/")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Contains(t, body, "<th>Order ID</th>")
+		assert.Contains(t, body, testOrderID)
+	})
+}
+
+func TestUI_Mount(t *testing.T) {
+	db, err := sql.Open("postgres", "postgres://l0:l0@127.0.0.1:1/l0?sslmode=disable&connect_timeout=1")
+	require.Equal(t, nil, err)
+	defer db.Close()
+	prev := postgres.DBWorker.DB
+	postgres.DBWorker.DB = db
+	defer func() { postgres.DBWorker.DB = prev }()
+	r := chi.NewRouter()
+	r.Mount("/admin/ui", NewUI(zap.NewNop().Sugar()).Router())
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, body := get(t, srv.URL+"/admin/ui/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, `href="/admin/ui/static/style.css"`)
+	assert.Contains(t, body, `action="/admin/ui/search"`)
+
+	res, _ = get(t, srv.URL+"/admin/ui/static/style.css")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, _ = get(t, srv.URL+"/admin/ui/search?q=a%2Fb&by=id")
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	assert.Equal(t, "/admin/ui/order/a%2Fb", res.Header.Get("Location"))
+
+	// errors of Psql db are not shown on page
+	res, body = get(t, srv.URL+"/admin/ui/search?q=track&by=track")
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Contains(t, body, "Orders are unavailable now, try again later")
+	assert.NotContains(t, body, "127.0.0.1")
+}
+
+func TestUI_Render(t *testing.T) {
+	u := NewUI(zap.NewNop().Sugar())
+	b, err := postgres.Read(testOrderJSON)
+	require.Equal(t, nil, err)
+	ord := order.NewOrder()
+	err = json.Unmarshal([]byte(b), &ord)
+	require.Equal(t, nil, err)
+	empty := order.NewOrder()
+	empty.OrderID = "empty_order"
+
+	tests := []struct {
+		name     string
+		tmpl     string
+		data     any
+		contains []string
+		missing  []string
+	}{
+		{
+			name: "index_recent",
+			tmpl: "index",
+			data: indexPage{
+				Base: "/ui",
+				By:   SearchByID,
+				Recent: []history.Record{
+					{OrderID: testOrderID, TriggeredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+				},
+			},
+			contains: []string{"<th>Order ID</th>", "/ui/order/" + testOrderID, "2024-01-02 03:04:05"},
+			missing:  []string{"No lookups yet"},
+		},
+		{
+			name:     "index_empty",
+			tmpl:     "index",
+			data:     indexPage{Base: "/ui", By: SearchByID, Error: "Search query is empty"},
+			contains: []string{"No lookups yet", "Search query is empty"},
+			missing:  []string{"<th>Order ID</th>"},
+		},
+		{
+			name: "order_items",
+			tmpl: "order",
+			data: orderPage{Query: testOrderID, By: SearchByID, Order: &ord},
+			contains: []string{
+				"<h3>Payment</h3>", "<th>Transaction</th>", "<th>Chrt ID</th>",
+				"Mascaras", "Vivienne Sabo",
+			},
+			missing: []string{"Order has no items"},
+		},
+		{
+			name:     "order_no_items",
+			tmpl:     "order",
+			data:     orderPage{Query: empty.OrderID, By: SearchByID, Order: &empty},
+			contains: []string{"Order empty_order", "Order has no items"},
+			missing:  []string{"<th>Chrt ID</th>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := u.indexTmpl
+			if tt.tmpl == "order" {
+				tmpl = u.orderTmpl
+			}
+			rec := httptest.NewRecorder()
+			u.render(rec, tmpl, http.StatusOK, tt.data)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			for _, s := range tt.contains {
+				assert.Contains(t, rec.Body.String(), s)
+			}
+			for _, s := range tt.missing {
+				assert.NotContains(t, rec.Body.String(), s)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for webhooks pointing to this host or internal network
+var ErrForbiddenAddress = errors.New("webhook address is loopback, link-local or private")
+
+// Resolver looks up addresses of webhook host on registration
+var Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+} = net.DefaultResolver
+
+// Forbidden reports addresses which webhook must not reach,
+// otherwise anyone registering webhook could probe internal services
+func Forbidden(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// CheckHost resolves host of webhook url, every its address has to be public
+func CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if Forbidden(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+		}
+		return nil
+	}
+	addrs, err := Resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("Problem with resolving of '%s': %w", host, err)
+	}
+	for _, addr := range addrs {
+		if Forbidden(addr.IP) {
+			return fmt.Errorf("%w: '%s' resolves to %s", ErrForbiddenAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialControl checks address which is really dialed, so host resolving
+// to other address after registration is rejected on delivery too
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || Forbidden(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// guardedTransport dials only public addresses, proxy from environment
+// is not used as it would be dialed instead of webhook
+func guardedTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   DeliveryTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,125 @@
+package webhooks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/akashipov/L0project/internal/arguments"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"go.uber.org/zap"
+)
+
+const (
+	PollInterval = time.Second
+	BatchSize    = 100
+	Concurrency  = 8
+	MaxBackoff   = time.Hour
+)
+
+// Dispatcher moves events from outbox to deliveries and sends them with retries
+type Dispatcher struct {
+	Worker      *postgres.SqlWorker
+	Sender      *Sender
+	Log         *zap.SugaredLogger
+	MaxAttempts int
+	BaseBackoff time.Duration
+}
+
+func NewDispatcher(log *zap.SugaredLogger) *Dispatcher {
+	return &Dispatcher{
+		Worker:      &postgres.DBWorker,
+		Sender:      NewSender(),
+		Log:         log,
+		MaxAttempts: arguments.WebhookMaxAttempts,
+		BaseBackoff: time.Duration(arguments.WebhookBackoffSecs) * time.Second,
+	}
+}
+
+func (d *Dispatcher) Run(done chan struct{}, w *sync.WaitGroup) {
+	defer w.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		d.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			d.Log.Infof("Webhook dispatcher is stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick dispatches pending outbox events and sends due deliveries
+func (d *Dispatcher) Tick(ctx context.Context) {
+	for {
+		n, err := d.Worker.DispatchOutbox(ctx, BatchSize)
+		if err != nil {
+			d.Log.Warnf("Problem with dispatching of webhook outbox: %s", err.Error())
+			break
+		}
+		if n < BatchSize {
+			break
+		}
+	}
+	lease := DeliveryTimeout * 2
+	deliveries, err := d.Worker.ClaimDueDeliveries(ctx, BatchSize, lease)
+	if err != nil {
+		d.Log.Warnf("Problem with claiming of webhook deliveries: %s", err.Error())
+		return
+	}
+	sem := make(chan struct{}, Concurrency)
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(delivery *webhook.Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.deliver(ctx, delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *webhook.Delivery) {
+	attempts := delivery.Attempts + 1
+	err := d.Sender.Send(ctx, delivery)
+	if ctx.Err() != nil {
+		// attempt was interrupted by shutdown, lease expires and delivery is retried
+		return
+	}
+	if err == nil {
+		err = d.Worker.MarkDeliverySucceeded(ctx, delivery.ID, attempts)
+		if err != nil {
+			d.Log.Warnf("Problem with marking of webhook delivery %d: %s", delivery.ID, err.Error())
+		}
+		return
+	}
+	d.Log.Infof(
+		"Webhook %d delivery %d attempt %d failed: %s",
+		delivery.Webhook.ID, delivery.ID, attempts, err.Error(),
+	)
+	if attempts >= d.MaxAttempts {
+		err = d.Worker.MoveDeliveryToDeadLetters(ctx, delivery, attempts, err.Error())
+		if err != nil {
+			d.Log.Warnf("Problem with moving of webhook delivery %d to dead letters: %s", delivery.ID, err.Error())
+		}
+		return
+	}
+	next := time.Now().Add(Backoff(attempts, d.BaseBackoff, MaxBackoff))
+	err = d.Worker.MarkDeliveryFailed(ctx, delivery.ID, attempts, next, err.Error())
+	if err != nil {
+		d.Log.Warnf("Problem with marking of webhook delivery %d: %s", delivery.ID, err.Error())
+	}
+}
@@ -0,0 +1,163 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	customerrors "github.com/akashipov/L0project/internal/errors"
+	"github.com/akashipov/L0project/internal/storage/postgres"
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/go-chi/chi/v5"
+)
+
+const DeadLettersLimit = 100
+
+// Registration is a body of request to register webhook,
+// secret is generated when it is empty
+type Registration struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types"`
+}
+
+// Router serves webhook registration relative to the mount point, e.g. '/webhooks' of admin server
+func Router() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", ListWebhooks)
+	r.Post("/", CreateWebhook)
+	r.Delete("/{id}", DeleteWebhook)
+	r.Get("/dead-letters", ListDeadLetters)
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		cErr := customerrors.CustomError{Message: err.Error(), Status: http.StatusInternalServerError}
+		cErr.ReportError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func internalError(w http.ResponseWriter, err error) {
+	cErr := customerrors.CustomError{Message: err.Error(), Status: http.StatusInternalServerError}
+	cErr.ReportError(w)
+}
+
+func (reg *Registration) Validate(ctx context.Context) *customerrors.CustomError {
+	u, err := url.Parse(reg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &customerrors.CustomError{
+			Message: fmt.Sprintf("Bad webhook url '%s', absolute http(s) url is expected", reg.URL),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	err = CheckHost(ctx, u.Hostname())
+	if err != nil {
+		return &customerrors.CustomError{
+			Message: fmt.Sprintf("Bad webhook url '%s': %s", reg.URL, err.Error()),
+			Status:  http.StatusBadRequest,
+		}
+	}
+	for _, t := range reg.EventTypes {
+		known := false
+		for _, k := range webhook.EventTypes {
+			known = known || t == k
+		}
+		if !known {
+			return &customerrors.CustomError{
+				Message: fmt.Sprintf("Unknown event type '%s'", t),
+				Status:  http.StatusBadRequest,
+			}
+		}
+	}
+	return nil
+}
+
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := postgres.DBWorker.GetWebhooks(r.Context(), nil)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var reg Registration
+	err := json.NewDecoder(r.Body).Decode(&reg)
+	if err != nil {
+		cErr := customerrors.CustomError{
+			Message: "Problem with decoding of webhook: " + err.Error(),
+			Status:  http.StatusBadRequest,
+		}
+		cErr.ReportError(w)
+		return
+	}
+	cErr := reg.Validate(r.Context())
+	if cErr != nil {
+		cErr.ReportError(w)
+		return
+	}
+	if reg.Secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		reg.Secret = hex.EncodeToString(b)
+	}
+	if reg.EventTypes == nil {
+		reg.EventTypes = []string{}
+	}
+	hook := webhook.Webhook{URL: reg.URL, Secret: reg.Secret, EventTypes: reg.EventTypes}
+	err = postgres.DBWorker.AddWebhook(r.Context(), nil, &hook)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	// secret is shown only once on registration
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		cErr := customerrors.CustomError{Message: "Bad webhook id: " + err.Error(), Status: http.StatusBadRequest}
+		cErr.ReportError(w)
+		return
+	}
+	found, err := postgres.DBWorker.DeleteWebhook(r.Context(), nil, id)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if !found {
+		cErr := customerrors.CustomError{Message: fmt.Sprintf("Webhook %d was not found", id), Status: http.StatusNotFound}
+		cErr.ReportError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := postgres.DBWorker.GetDeadLetters(r.Context(), nil, DeadLettersLimit)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akashipov/L0project/internal/storage/webhook"
+)
+
+const (
+	// SignatureHeader is 'sha256=<hex hmac>' of '<timestamp>.<body>' with webhook secret as a key
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// DeliveryTimeout limits one attempt to send event to webhook
+const DeliveryTimeout = 10 * time.Second
+
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify is used by receivers to check that event was sent by us
+func Verify(secret string, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff returns delay before next attempt, it doubles after every failed attempt up to max
+func Backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+type Sender struct {
+	Client *http.Client
+}
+
+// NewSender delivers events only to public addresses
+func NewSender() *Sender {
+	return &Sender{Client: &http.Client{Timeout: DeliveryTimeout, Transport: guardedTransport()}}
+}
+
+// Send posts event to webhook, any status except 2xx is an error
+func (s *Sender) Send(ctx context.Context, d *webhook.Delivery) error {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return fmt.Errorf("Problem with encoding of event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Problem with creation of request: %w", err)
+	}
+	t := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(t, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Webhook.Secret, t, body))
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Problem with sending of event: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/akashipov/L0project/internal/storage/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first", attempt: 1, want: time.Second},
+		{name: "second", attempt: 2, want: 2 * time.Second},
+		{name: "fifth", attempt: 5, want: 16 * time.Second},
+		{name: "capped", attempt: 30, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Backoff(tt.attempt, time.Second, time.Minute))
+		})
+	}
+}
+
+func TestSender_Send(t *testing.T) {
+	delivery := webhook.Delivery{
+		ID:      7,
+		Webhook: webhook.Webhook{ID: 1, Secret: "secret"},
+		Event: webhook.Event{
+			ID:      3,
+			Type:    webhook.EventOrderStored,
+			OrderID: "b563feb7b2b84b6test",
+			Data:    json.RawMessage(`{"order_uid":"b563feb7b2b84b6test"}`),
+		},
+	}
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "accepted", status: http.StatusOK, wantErr: false},
+		{name: "no_content", status: http.StatusNoContent, wantErr: false},
+		{name: "server_error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "gone", status: http.StatusGone, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan webhook.Event, 1)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.Equal(t, nil, err)
+				ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+				require.Equal(t, nil, err)
+				assert.True(t, Verify("secret", r.Header.Get(SignatureHeader), ts, body))
+				assert.False(t, Verify("other", r.Header.Get(SignatureHeader), ts, body))
+				assert.Equal(t, webhook.EventOrderStored, r.Header.Get(EventHeader))
+				assert.Equal(t, "7", r.Header.Get(DeliveryHeader))
+				var e webhook.Event
+				require.Equal(t, nil, json.Unmarshal(body, &e))
+				received <- e
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+			d := delivery
+			d.Webhook.URL = receiver.URL
+			// receiver listens on loopback, so sender without address guard is used
+			sender := &Sender{Client: &http.Client{Timeout: DeliveryTimeout}}
+			err := sender.Send(context.Background(), &d)
+			assert.Equal(t, tt.wantErr, err != nil)
+			e := <-received
+			assert.Equal(t, delivery.Event.ID, e.ID)
+			assert.Equal(t, delivery.Event.OrderID, e.OrderID)
+			assert.JSONEq(t, string(delivery.Event.Data), string(e.Data))
+		})
+	}
+}
+
+func TestSender_SendForbidden(t *testing.T) {
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+	d := webhook.Delivery{Webhook: webhook.Webhook{URL: receiver.URL}, Event: webhook.Event{Type: webhook.EventOrderStored}}
+	err := NewSender().Send(context.Background(), &d)
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+	assert.False(t, called)
+}
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := f[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestRegistration_Validate(t *testing.T) {
+	Resolver = fakeResolver{
+		"example.com":       {"93.184.216.34"},
+		"internal.example":  {"93.184.216.34", "10.0.0.5"},
+		"localhost":         {"127.0.0.1", "::1"},
+		"metadata.internal": {"169.254.169.254"},
+	}
+	defer func() { Resolver = net.DefaultResolver }()
+	tests := []struct {
+		name    string
+		reg     Registration
+		wantErr bool
+	}{
+		{name: "all_events", reg: Registration{URL: "https://example.com/hook"}, wantErr: false},
+		{name: "filtered", reg: Registration{URL: "http://example.com", EventTypes: []string{webhook.EventOrderRejected}}, wantErr: false},
+		{name: "relative_url", reg: Registration{URL: "/hook"}, wantErr: true},
+		{name: "bad_scheme", reg: Registration{URL: "ftp://example.com"}, wantErr: true},
+		{name: "unknown_event", reg: Registration{URL: "https://example.com", EventTypes: []string{"order.lost"}}, wantErr: true},
+		{name: "public_ip", reg: Registration{URL: "https://93.184.216.34:8443/hook"}, wantErr: false},
+		{name: "loopback_ip", reg: Registration{URL: "http://127.0.0.1:8081/cache"}, wantErr: true},
+		{name: "loopback_ipv6", reg: Registration{URL: "http://[::1]/hook"}, wantErr: true},
+		{name: "loopback_name", reg: Registration{URL: "http://localhost/hook"}, wantErr: true},
+		{name: "link_local", reg: Registration{URL: "http://169.254.169.254/latest/meta-data"}, wantErr: true},
+		{name: "link_local_name", reg: Registration{URL: "http://metadata.internal/"}, wantErr: true},
+		{name: "private", reg: Registration{URL: "http://192.168.1.10/hook"}, wantErr: true},
+		{name: "private_ipv6", reg: Registration{URL: "http://[fd00::1]/hook"}, wantErr: true},
+		{name: "one_of_addresses_private", reg: Registration{URL: "https://internal.example/hook"}, wantErr: true},
+		{name: "unspecified", reg: Registration{URL: "http://0.0.0.0/hook"}, wantErr: true},
+		{name: "not_resolved", reg: Registration{URL: "https://unknown.example/hook"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cErr := tt.reg.Validate(context.Background())
+			assert.Equal(t, tt.wantErr, cErr != nil)
+			if cErr != nil {
+				assert.Equal(t, http.StatusBadRequest, int(cErr.Status))
+			}
+		})
+	}
+}
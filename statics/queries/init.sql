@@ -46,6 +46,9 @@ CREATE TABLE IF NOT EXISTS orders (
     date_created TIMESTAMPTZ NOT NULL
 );
 
+-- digest of order tells message delivered again from order sent with other content
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payload_sha256 VARCHAR(64) NOT NULL DEFAULT '';
+
 -- DO $$
 -- BEGIN
 --     IF NOT EXISTS(
@@ -90,3 +93,75 @@ CREATE TABLE IF NOT EXISTS history (
     order_id VARCHAR(50) PRIMARY KEY,
     triggered_at TIMESTAMPTZ
 );
+
+CREATE TABLE IF NOT EXISTS order_access (
+    order_id VARCHAR(50) NOT NULL,
+    bucket TIMESTAMPTZ NOT NULL,
+    hits BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (order_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS order_access_bucket_idx ON order_access (bucket);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(128) NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    order_id VARCHAR(50) NOT NULL DEFAULT '',
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    dispatched_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webhook_outbox_pending ON webhook_outbox(id) WHERE dispatched_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL,
+    CONSTRAINT fk_webhook_id_deliveries FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE,
+    outbox_id BIGINT NOT NULL,
+    CONSTRAINT fk_outbox_id_deliveries FOREIGN KEY(outbox_id) REFERENCES webhook_outbox(id),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered_at TIMESTAMPTZ,
+    CONSTRAINT unique_delivery UNIQUE (webhook_id, outbox_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE delivered_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL,
+    outbox_id BIGINT NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    order_id VARCHAR(50) NOT NULL DEFAULT '',
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS nats_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    subject VARCHAR(200) NOT NULL,
+    order_id VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    relayed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS nats_outbox_pending ON nats_outbox(id) WHERE relayed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS schema_version (
+    id INTEGER PRIMARY KEY,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);